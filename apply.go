@@ -0,0 +1,369 @@
+package xdiff
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// Apply changes the xtree by running the edit script produced by comparing
+// it with the other xtree, after which the xtree has the same contents as
+// the other one. Nodes are found by the paths in the Left references of the
+// deltas, so the xtree doesn't have to be the one that was compared, just
+// the one with the same contents. Inserted nodes are copied so the deltas
+// can be applied again. Namespaces, signatures and hashes of the xtree are
+// recalculated.
+//
+// Children of unordered parents are not guaranteed to end up in the same
+// order as in the other xtree since their order is not significant.
+func Apply(tree *xtree.Node, deltas []Delta) error {
+	if err := edit(tree, deltas, treeEditor{}); err != nil {
+		return err
+	}
+	xtree.ResolveNamespaces(tree)
+	return xtree.Prepare(tree)
+}
+
+// edit runs the edit script on the xtree making the changes with the editor.
+func edit(tree *xtree.Node, deltas []Delta, e editor) error {
+	// All the paths are resolved before the xtree is changed since they
+	// address the nodes of the original xtree.
+	changes := make([]change, len(deltas))
+	for i, d := range deltas {
+		ch, err := resolve(tree, d)
+		if err != nil {
+			return err
+		}
+		changes[i] = ch
+	}
+	// Nodes are detached first and placed back once all the siblings
+	// are in place, ordered by the position of their counterparts.
+	placements := make(map[*xtree.Node][]placement)
+	var parents []*xtree.Node
+	place := func(parent, n *xtree.Node, index int) {
+		if _, ok := placements[parent]; !ok {
+			parents = append(parents, parent)
+		}
+		placements[parent] = append(placements[parent], placement{n, index})
+	}
+	for i, d := range deltas {
+		ch := changes[i]
+		var err error
+		switch d.Operation {
+		case Update:
+			err = e.Update(ch.node, d.Right.Node.Value)
+		case Rename:
+			err = e.Rename(ch.node, d.Right.Node.Name)
+		case Delete, DeleteSubtree:
+			err = e.Remove(ch.node)
+		case Insert, InsertSubtree:
+			place(ch.parent, d.Right.Node.Clone(), d.Left.Index)
+		case Move, Reorder:
+			err = e.Remove(ch.node)
+			if ch.parent != nil {
+				place(ch.parent, ch.node, d.Left.Index)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, parent := range parents {
+		nodes := placements[parent]
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].position < nodes[j].position
+		})
+		for _, p := range nodes {
+			if err := e.Insert(parent, p.node, childAt(parent, p.position)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// editor makes the changes to the xtree one node at a time.
+type editor interface {
+	// Update sets the value of the node.
+	Update(n *xtree.Node, value []byte) error
+	// Rename sets the name of the node.
+	Rename(n *xtree.Node, name []byte) error
+	// Remove detaches the node from its parent.
+	Remove(n *xtree.Node) error
+	// Insert adds the node to the parent before the other child, or as the
+	// last child if the other one is nil.
+	Insert(parent, n, before *xtree.Node) error
+}
+
+// treeEditor changes the xtree directly.
+type treeEditor struct{}
+
+func (treeEditor) Update(n *xtree.Node, value []byte) error {
+	n.Value = append([]byte{}, value...)
+	return nil
+}
+
+func (treeEditor) Rename(n *xtree.Node, name []byte) error {
+	n.Name = append([]byte{}, name...)
+	return nil
+}
+
+func (treeEditor) Remove(n *xtree.Node) error {
+	n.Remove()
+	return nil
+}
+
+func (treeEditor) Insert(parent, n, before *xtree.Node) error {
+	parent.InsertBefore(n, before)
+	return nil
+}
+
+// Invert generates edit script that reverses the changes made by the deltas.
+// Roles of the compared xtrees are swapped, so the inverted script changes
+// the right xtree into the left one.
+func Invert(deltas []Delta) []Delta {
+	inverted := make([]Delta, len(deltas))
+	for i, d := range deltas {
+		inv := Delta{
+			Operation:   d.Operation,
+			Subject:     d.Object,
+			Object:      d.Subject,
+			LeftParent:  d.RightParent,
+			RightParent: d.LeftParent,
+			Left:        d.Right,
+			Right:       d.Left,
+		}
+		switch d.Operation {
+		case Insert:
+			inv.Operation = Delete
+		case Delete:
+			inv.Operation = Insert
+		case InsertSubtree:
+			inv.Operation = DeleteSubtree
+		case DeleteSubtree:
+			inv.Operation = InsertSubtree
+		}
+		switch d.Operation {
+		case Insert, Delete, InsertSubtree, DeleteSubtree:
+			inv.Subject, inv.Object = d.Subject, d.Object
+		}
+		inverted[len(deltas)-1-i] = inv
+	}
+	return inverted
+}
+
+// placement is the node that is placed to the position among its siblings.
+type placement struct {
+	node     *xtree.Node
+	position int
+}
+
+// change holds the nodes of the xtree that are changed by the delta.
+type change struct {
+	node   *xtree.Node
+	parent *xtree.Node
+}
+
+// resolve finds the nodes changed by the delta in the xtree.
+func resolve(tree *xtree.Node, d Delta) (change, error) {
+	var ch change
+	var err error
+	switch d.Operation {
+	case Insert, InsertSubtree:
+		if d.Right.Node == nil {
+			return ch, fmt.Errorf("xdiff: %s into %q without inserted node", d.Operation, d.Left.Parent)
+		}
+		if d.Left.Parent == "" {
+			return ch, fmt.Errorf("xdiff: %s of %s without parent", d.Operation, d.Right.Node)
+		}
+		ch.parent, err = tree.Find(d.Left.Parent)
+		return ch, err
+	case Update, Rename:
+		if d.Right.Node == nil {
+			return ch, fmt.Errorf("xdiff: %s of %q without new node", d.Operation, d.Left.Path)
+		}
+	case Delete, DeleteSubtree, Reorder, Move:
+	default:
+		return ch, fmt.Errorf("xdiff: unknown operation %s", d.Operation)
+	}
+	if d.Left.Path == "" {
+		return ch, fmt.Errorf("xdiff: %s without path", d.Operation)
+	}
+	if ch.node, err = tree.Find(d.Left.Path); err != nil {
+		return ch, err
+	}
+	switch d.Operation {
+	case Delete, DeleteSubtree, Reorder, Move:
+		if ch.node.Parent == nil {
+			return ch, fmt.Errorf("xdiff: %s of the root %q", d.Operation, d.Left.Path)
+		}
+	}
+	ch.parent = ch.node.Parent
+	if d.Operation == Move && d.Left.Parent == "" {
+		// Node is moved into the inserted subtree which carries it.
+		ch.parent = nil
+	} else if d.Operation == Move {
+		if ch.parent, err = tree.Find(d.Left.Parent); err != nil {
+			return ch, err
+		}
+		if inTree(ch.node, ch.parent) {
+			return ch, fmt.Errorf("xdiff: %s of %q into its own subtree %q", d.Operation, d.Left.Path, d.Left.Parent)
+		}
+	}
+	return ch, nil
+}
+
+// locate sets references of the deltas to the changed nodes.
+func locate(script []Delta) {
+	lc := locator{
+		paths:     make(map[*xtree.Node]string),
+		positions: make(map[*xtree.Node]int),
+	}
+	for i := range script {
+		d := &script[i]
+		// Nodes changed by the delta in the left and right xtree.
+		var left, right *xtree.Node
+		switch d.Operation {
+		case Insert, InsertSubtree:
+			right = d.Subject
+		case Delete, DeleteSubtree:
+			left = d.Subject
+		default:
+			left, right = d.Subject, d.Object
+		}
+		switch d.Operation {
+		case Insert, InsertSubtree:
+			d.Left = lc.Ref(nil, right, d.LeftParent)
+			d.Right = lc.Ref(right, nil, nil)
+			d.Right.Node = right.Clone()
+		case Delete, DeleteSubtree:
+			d.Left = lc.Ref(left, nil, nil)
+			d.Right = lc.Ref(nil, left, d.RightParent)
+			d.Left.Node = left.Clone()
+		case Move:
+			d.Left = lc.Ref(left, right, d.LeftParent)
+			d.Right = lc.Ref(right, left, d.RightParent)
+			if d.LeftParent == nil {
+				d.Left.Parent = ""
+			}
+		case Reorder:
+			d.Left = lc.Ref(left, right, left.Parent)
+			d.Right = lc.Ref(right, left, right.Parent)
+		default:
+			d.Left = lc.Ref(left, nil, nil)
+			d.Right = lc.Ref(right, nil, nil)
+		}
+		if d.Left.Node == nil && left != nil {
+			d.Left.Node = detached(left)
+		}
+		if d.Right.Node == nil && right != nil {
+			d.Right.Node = detached(right)
+		}
+	}
+}
+
+// detached returns copy of the node without its children.
+func detached(n *xtree.Node) *xtree.Node {
+	return &xtree.Node{
+		Type:      n.Type,
+		Name:      append([]byte{}, n.Name...),
+		Value:     append([]byte{}, n.Value...),
+		Namespace: n.Namespace,
+		Start:     n.Start,
+		End:       n.End,
+	}
+}
+
+// locator caches paths and positions of the nodes.
+type locator struct {
+	paths     map[*xtree.Node]string
+	positions map[*xtree.Node]int
+}
+
+// Ref returns reference to the node which is placed under the parent at the
+// position of its counterpart. Without the counterpart the node stays at its
+// position, and without the parent it stays under its own parent.
+func (lc locator) Ref(n, counterpart, parent *xtree.Node) NodeRef {
+	var ref NodeRef
+	if n != nil {
+		ref.Path = lc.Path(n)
+		ref.Index = lc.Position(n)
+		if parent == nil {
+			parent = n.Parent
+		}
+	}
+	if counterpart != nil {
+		ref.Index = lc.Position(counterpart)
+	}
+	if parent != nil {
+		ref.Parent = lc.Path(parent)
+	}
+	return ref
+}
+
+// Path returns path of the node from the root of its xtree.
+func (lc locator) Path(n *xtree.Node) string {
+	if n.Parent == nil {
+		return "/"
+	}
+	if _, ok := lc.paths[n]; !ok {
+		lc.index(n.Parent)
+	}
+	return lc.paths[n]
+}
+
+// Position returns index of the node among its siblings.
+func (lc locator) Position(n *xtree.Node) int {
+	if n.Parent == nil {
+		return 0
+	}
+	if _, ok := lc.positions[n]; !ok {
+		lc.index(n.Parent)
+	}
+	return lc.positions[n]
+}
+
+// index caches paths and positions of all the children of the node, so
+// large number of siblings is only traversed once.
+func (lc locator) index(n *xtree.Node) {
+	parent := lc.Path(n)
+	if parent == "/" {
+		parent = ""
+	}
+	steps := n.ChildSteps()
+	i := 0
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		lc.paths[ch] = parent + "/" + steps[i]
+		lc.positions[ch] = i
+		i++
+	}
+}
+
+// inTree returns true if the node belongs to the xtree.
+func inTree(tree, n *xtree.Node) bool {
+	for ; n != nil; n = n.Parent {
+		if n == tree {
+			return true
+		}
+	}
+	return false
+}
+
+// position returns index of the node among its siblings.
+func position(n *xtree.Node) int {
+	i := 0
+	for prev := n.PrevSibling(); prev != nil; prev = prev.PrevSibling() {
+		i++
+	}
+	return i
+}
+
+// childAt returns child of the node at the index or nil if there is none.
+func childAt(n *xtree.Node, index int) *xtree.Node {
+	ch := n.FirstChild
+	for ; ch != nil && index > 0; index-- {
+		ch = ch.NextSibling
+	}
+	return ch
+}
@@ -0,0 +1,219 @@
+package xdiff
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		left  *xtree.Node
+		right *xtree.Node
+	}{
+		{"Update", doc("", el("root", el("a", dat("1")))), doc("", el("root", el("a", dat("2"))))},
+		{"Insert", doc("", el("root", el("a"))), doc("", el("root", el("a", attr("id", "1"), el("b", dat("2")))))},
+		{"Delete", doc("", el("root", el("a", attr("id", "1"), el("b", dat("2"))))), doc("", el("root", el("a")))},
+		{"Move",
+			doc("", el("root", el("a", el("c", dat("1"), dat("2"))), el("b"))),
+			doc("", el("root", el("a"), el("b", el("c", dat("1"), dat("2")))))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xtree.Prepare(tt.left)
+			xtree.Prepare(tt.right)
+			script, err := Compare(tt.left, tt.right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Apply(tt.left, script); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tt.left.Hash, tt.right.Hash) {
+				l, _ := xtree.TextString(tt.left)
+				r, _ := xtree.TextString(tt.right)
+				t.Errorf("Apply() =\n%s, want\n%s", l, r)
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		delta Delta
+	}{
+		{"Without path", Delta{Operation: Delete}},
+		{"Relative path", Delta{Operation: Delete, Left: NodeRef{Path: "root[1]"}}},
+		{"Unknown operation", Delta{Operation: Operation(100), Left: NodeRef{Path: "/root[1]"}}},
+		{"Missing node", Delta{Operation: Delete, Left: NodeRef{Path: "/root[1]/b[1]"}}},
+		{"Invalid position", Delta{Operation: Delete, Left: NodeRef{Path: "/root[1]/a[0]"}}},
+		{"Delete root", Delta{Operation: DeleteSubtree, Left: NodeRef{Path: "/"}}},
+		{"Update without new node", Delta{Operation: Update, Left: NodeRef{Path: "/root[1]/a[1]/text()[1]"}}},
+		{"Insert without parent", Delta{Operation: Insert, Right: NodeRef{Node: el("b")}}},
+		{"Insert without node", Delta{Operation: Insert, Left: NodeRef{Parent: "/root[1]"}}},
+		{"Insert into missing parent", Delta{Operation: Insert, Left: NodeRef{Parent: "/root[1]/c[1]"}, Right: NodeRef{Node: el("b")}}},
+		{"Move into own subtree", Delta{Operation: Move, Left: NodeRef{Path: "/root[1]/a[1]", Parent: "/root[1]/a[1]"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left := doc("", el("root", el("a", dat("1"))))
+			xtree.Prepare(left)
+			if err := Apply(left, []Delta{tt.delta}); err == nil {
+				t.Error("Apply() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestApplyComparedScript(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		for _, mode := range []struct {
+			ordered bool
+			renames bool
+		}{{false, false}, {true, false}, {false, true}, {true, true}} {
+			ordered := mode.ordered
+			t.Run(fmt.Sprintf("Seed %d ordered %v renames %v", seed, mode.ordered, mode.renames), func(t *testing.T) {
+				c := NewComparer()
+				c.Ordered = mode.ordered
+				c.DetectRenames = mode.renames
+
+				left, right := randomTrees(seed)
+				script, err := c.Compare(left, right)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Script is applied to fresh copies of the compared xtrees
+				// to make sure the deltas don't depend on them.
+				script = detach(script)
+
+				left, right = randomTrees(seed)
+				if err := Apply(left, script); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(canonicalHash(left, ordered), canonicalHash(right, ordered)) {
+					l, _ := xtree.TextString(left)
+					r, _ := xtree.TextString(right)
+					t.Fatalf("Apply(left, %v) =\n%s, want\n%s", script, l, r)
+				}
+
+				left, right = randomTrees(seed)
+				if err := Apply(right, Invert(script)); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(canonicalHash(left, ordered), canonicalHash(right, ordered)) {
+					l, _ := xtree.TextString(left)
+					r, _ := xtree.TextString(right)
+					t.Fatalf("Apply(right, %v) =\n%s, want\n%s", Invert(script), r, l)
+				}
+			})
+		}
+	}
+}
+
+// detach removes references to the compared xtrees from the deltas.
+func detach(script []Delta) []Delta {
+	detached := make([]Delta, len(script))
+	for i, d := range script {
+		detached[i] = Delta{Operation: d.Operation, Left: d.Left, Right: d.Right}
+	}
+	return detached
+}
+
+// randomTrees generates random xtree and its randomly edited copy.
+func randomTrees(seed int64) (*xtree.Node, *xtree.Node) {
+	rnd := rand.New(rand.NewSource(seed))
+	names := []string{"a", "b", "c"}
+	var generate func(depth int) *xtree.Node
+	generate = func(depth int) *xtree.Node {
+		n := el(names[rnd.Intn(len(names))])
+		if rnd.Intn(2) == 0 {
+			n.AppendChild(attr("id", fmt.Sprint(rnd.Intn(3))))
+		}
+		if rnd.Intn(3) == 0 {
+			n.AppendChild(attr("type", fmt.Sprint(rnd.Intn(3))))
+		}
+		for i := rnd.Intn(4); depth > 0 && i > 0; i-- {
+			if rnd.Intn(3) == 0 {
+				n.AppendChild(dat(fmt.Sprint(rnd.Intn(5))))
+				continue
+			}
+			n.AppendChild(generate(depth - 1))
+		}
+		return n
+	}
+	left := doc("", el("root", generate(3), generate(3), generate(3)))
+	right := left.Clone()
+
+	var elements []*xtree.Node
+	postOrder(right.FirstChild, nil, func(n *xtree.Node) {
+		if n.Type == xtree.Element && n != right.FirstChild {
+			elements = append(elements, n)
+		}
+	})
+	for i := rnd.Intn(4) + 1; i > 0 && len(elements) > 0; i-- {
+		n := elements[rnd.Intn(len(elements))]
+		if n.Parent == nil {
+			continue
+		}
+		switch rnd.Intn(6) {
+		case 0:
+			n.AppendChild(generate(1))
+		case 1:
+			if n.Parent.Type == xtree.Element {
+				n.Remove()
+			}
+		case 2:
+			if ch := n.LastChild(); ch != nil && ch.Type != xtree.Element {
+				ch.Value = []byte("edited")
+			}
+		case 3:
+			target := elements[rnd.Intn(len(elements))]
+			if target.Parent != nil && !inTree(n, target) {
+				n.Remove()
+				target.AppendChild(n)
+			}
+		case 4:
+			if prev := n.PrevSibling(); prev != nil && prev.Type == xtree.Element {
+				n.Remove()
+				prev.Parent.InsertBefore(n, prev)
+			}
+		case 5:
+			n.Name = []byte("d")
+		}
+	}
+	xtree.Prepare(left)
+	xtree.Prepare(right)
+	return left, right
+}
+
+// canonicalHash calculates hash of the xtree which doesn't depend on the
+// order of attributes, or on the order of all children if it's unordered.
+func canonicalHash(n *xtree.Node, ordered bool) []byte {
+	var attrs, children [][]byte
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		if ch.Type == xtree.Attribute {
+			attrs = append(attrs, canonicalHash(ch, ordered))
+		} else {
+			children = append(children, canonicalHash(ch, ordered))
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+	if !ordered {
+		sort.Slice(children, func(i, j int) bool { return bytes.Compare(children[i], children[j]) < 0 })
+	}
+	h := sha1.New()
+	h.Write([]byte{byte(n.Type)})
+	h.Write(n.Name)
+	h.Write(n.Value)
+	for _, hash := range append(attrs, children...) {
+		h.Write(hash)
+	}
+	return h.Sum(nil)
+}
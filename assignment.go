@@ -0,0 +1,94 @@
+package xdiff
+
+import "math"
+
+// minCostAssignment solves the assignment problem for the given cost matrix
+// using the Hungarian algorithm in O(n²m) time. Every row is assigned to a
+// distinct column so that the total cost is minimal. Returned slice holds
+// the assigned column for every row. Matrix can't have more rows than columns.
+func minCostAssignment(cost [][]int) []int {
+	n := len(cost)
+	if n == 0 {
+		return nil
+	}
+	m := len(cost[0])
+	if n > m {
+		// Solve the transposed problem and invert the assignment.
+		transposed := make([][]int, m)
+		for j := range transposed {
+			transposed[j] = make([]int, n)
+			for i := range cost {
+				transposed[j][i] = cost[i][j]
+			}
+		}
+		rows := make([]int, n)
+		for i := range rows {
+			rows[i] = -1
+		}
+		for j, i := range minCostAssignment(transposed) {
+			rows[i] = j
+		}
+		return rows
+	}
+
+	// Potentials and matching are one-indexed, the zero column is
+	// a sentinel used for augmenting paths.
+	u := make([]int, n+1)
+	v := make([]int, m+1)
+	p := make([]int, m+1)
+	way := make([]int, m+1)
+	minv := make([]int, m+1)
+	used := make([]bool, m+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		for j := range minv {
+			minv[j] = math.MaxInt
+			used[j] = false
+		}
+		for {
+			used[j0] = true
+			i0 := p[j0]
+			delta := math.MaxInt
+			j1 := 0
+			for j := 1; j <= m; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost[i0-1][j-1] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+	rows := make([]int, n)
+	for j := 1; j <= m; j++ {
+		if p[j] != 0 {
+			rows[p[j]-1] = j - 1
+		}
+	}
+	return rows
+}
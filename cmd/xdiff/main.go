@@ -6,6 +6,7 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,12 +23,45 @@ var (
 	version     string
 	date        string
 	showVersion bool
+	ordered     bool
+	greedy      bool
+	noMoves     bool
+	renames     bool
+	renameRatio float64
+	noPrefixes  bool
+	format      string
+	output      string
+	context     int
+	parserName  string
+	scriptPath  string
+
+	orderedPaths   listFlag
+	unorderedPaths listFlag
+	keyPaths       listFlag
+	normalize      listFlag
+	normalizePaths listFlag
 )
 
 func main() {
 	flag.BoolVar(&showVersion, "version", false, "show build information.")
 	flag.StringVar(&leftSource, "left", "", "original source for comparison.")
 	flag.StringVar(&rightSource, "right", "", "edited source for comparison.")
+	flag.BoolVar(&ordered, "ordered", false, "treat order of child nodes as significant.")
+	flag.Var(&orderedPaths, "ordered-path", "comma separated signature `paths` of nodes with ordered children.")
+	flag.Var(&unorderedPaths, "unordered-path", "comma separated signature `paths` of nodes with unordered children.")
+	flag.Var(&keyPaths, "key", "comma separated `path=key` pairs identifying nodes by attribute (@id) or child element, join composite keys with +.")
+	flag.BoolVar(&greedy, "greedy", false, "use faster greedy matching which can produce longer diff.")
+	flag.BoolVar(&noMoves, "no-moves", false, "report moved subtrees as deletions and insertions.")
+	flag.BoolVar(&renames, "renames", false, "detect renamed elements and attributes.")
+	flag.Float64Var(&renameRatio, "rename-threshold", 0.8, "minimum similarity of contents, between 0 and 1, for renamed nodes.")
+	flag.BoolVar(&noPrefixes, "ignore-prefixes", false, "ignore changes of namespace prefixes and their declarations.")
+	flag.StringVar(&parserName, "parser", "fast", "`name` of the xml parser, fast or standard which is slower but more robust.")
+	flag.Var(&normalize, "normalize", "comma separated `normalizations` of text and attribute values, newlines, nfc, collapse, trim or drop-blank.")
+	flag.Var(&normalizePaths, "normalize-path", "comma separated `path=normalizations` pairs normalizing nodes by their signature paths, join normalizations with + or use none.")
+	flag.StringVar(&format, "format", "text", "output `format` of the edit script, text, json, xmlpatch, merged, html or terminal.")
+	flag.IntVar(&context, "context", 2, "number of unchanged sibling nodes shown around changes in terminal format.")
+	flag.StringVar(&output, "o", "", "write output to the `file` instead of STDOUT.")
+	flag.StringVar(&scriptPath, "apply", "", "apply the edit script from the JSON `file` to the left source and output the edited document.")
 	flag.StringVar(&cpuprofile, "cpuprofile", "", "write cpu profile to `file`.")
 	flag.StringVar(&memprofile, "memprofile", "", "write memory profile to `file`.")
 	flag.Parse()
@@ -37,9 +71,28 @@ func main() {
 		fmt.Println(date)
 		os.Exit(0)
 	}
+	if scriptPath != "" {
+		if leftSource == "" {
+			fail("filepath of the left source is required.")
+		}
+		if _, err := parser.Lookup(parserName); err != nil {
+			fail("%v", err)
+		}
+		applyScript(newNormalizer())
+		return
+	}
 	if leftSource == "" || rightSource == "" {
 		fail("filepaths of both sources are required.")
 	}
+	switch format {
+	case "text", "json", "xmlpatch", "merged", "html", "terminal":
+	default:
+		fail("unknown output format %q, expected text, json, xmlpatch, merged, html or terminal.", format)
+	}
+	if _, err := parser.Lookup(parserName); err != nil {
+		fail("%v", err)
+	}
+	normalizer := newNormalizer()
 	li, err := os.Stat(leftSource)
 	if err != nil {
 		fail("can't access left source %s error: %v",
@@ -50,6 +103,9 @@ func main() {
 		fail("can't access right source %s error: %v",
 			rightSource, err.Error())
 	}
+	if format == "merged" && (li.IsDir() || ri.IsDir()) {
+		fail("merged format can't be used for directories, compare their files instead.")
+	}
 	if cpuprofile != "" {
 		f, err := os.Create(cpuprofile)
 		if err != nil {
@@ -68,7 +124,7 @@ func main() {
 	go func() {
 		defer wg.Done()
 		var err error
-		p := parser.New()
+		p := newParser()
 		start := time.Now()
 
 		if li.IsDir() {
@@ -76,6 +132,9 @@ func main() {
 		} else {
 			left, err = p.ParseFile(leftSource)
 		}
+		if err == nil && normalizer != nil {
+			err = normalizer.Prepare(left)
+		}
 		if err != nil {
 			fail("failed to parse left file %s error: %v",
 				leftSource, err.Error())
@@ -86,13 +145,16 @@ func main() {
 	go func() {
 		defer wg.Done()
 		var err error
-		p := parser.New()
+		p := newParser()
 		start := time.Now()
 		if ri.IsDir() {
 			right, err = p.ParseDir(rightSource)
 		} else {
 			right, err = p.ParseFile(rightSource)
 		}
+		if err == nil && normalizer != nil {
+			err = normalizer.Prepare(right)
+		}
 		if err != nil {
 			fail("failed to parse right file %s error: %v",
 				rightSource, err.Error())
@@ -102,15 +164,72 @@ func main() {
 	wg.Wait()
 	fmt.Fprintf(os.Stderr, "total parsing time: %s\n", time.Since(start))
 	start = time.Now()
-	diff, err := xdiff.Compare(left, right)
+	cmp := xdiff.NewComparer()
+	cmp.Ordered = ordered
+	cmp.Greedy = greedy
+	cmp.DetectMoves = !noMoves
+	cmp.DetectRenames = renames
+	cmp.RenameThreshold = renameRatio
+	cmp.IgnorePrefixes = noPrefixes
+	if len(orderedPaths) > 0 || len(unorderedPaths) > 0 {
+		policy := xdiff.NewSignaturePolicy(orderedPaths...)
+		policy.Default = ordered
+		for _, path := range unorderedPaths {
+			policy.Set(path, false)
+		}
+		cmp.OrderPolicy = policy
+	}
+	if len(keyPaths) > 0 {
+		keys := xdiff.NewSignatureKeys()
+		for _, kp := range keyPaths {
+			i := strings.LastIndex(kp, "=")
+			if i <= 0 || i == len(kp)-1 {
+				fail("invalid key %q, expected path=key", kp)
+			}
+			keys.Set(kp[:i], strings.Split(kp[i+1:], "+")...)
+		}
+		cmp.Keys = keys
+	}
+	diff, err := cmp.Compare(left, right)
 	if err != nil {
 		fail("failed to compare files error: %v", err.Error())
 	}
 	fmt.Fprintf(os.Stderr, "comparing time: %s\n", time.Since(start))
-	enc := xdiff.NewTextEncoder(os.Stdout)
+	out := createOutput()
+	var enc interface {
+		Encode([]xdiff.Delta) error
+	}
+	switch format {
+	case "json":
+		js := xdiff.NewJSONEncoder(out)
+		js.LeftFile, js.RightFile = leftSource, rightSource
+		enc = js
+	case "xmlpatch":
+		enc = xdiff.NewXMLPatchEncoder(out, left)
+	case "merged":
+		merged := xdiff.NewMergedEncoder(out, right)
+		merged.Indent("  ")
+		enc = merged
+	case "html":
+		report := xdiff.NewHTMLEncoder(out, right)
+		report.Title = leftSource + " vs " + rightSource
+		enc = report
+	case "terminal":
+		terminal := xdiff.NewTerminalEncoder(out, right)
+		terminal.Context = context
+		terminal.LeftFile, terminal.RightFile = leftSource, rightSource
+		enc = terminal
+	default:
+		text := xdiff.NewTextEncoder(out)
+		text.LeftFile, text.RightFile = leftSource, rightSource
+		enc = text
+	}
 	if err := enc.Encode(diff); err != nil {
 		fail("failed to generate output error: %v", err.Error())
 	}
+	if err := out.Close(); err != nil {
+		fail("failed to write output error: %v", err.Error())
+	}
 
 	if memprofile != "" {
 		f, err := os.Create(memprofile)
@@ -125,6 +244,100 @@ func main() {
 	}
 }
 
+// applyScript applies the JSON edit script to the left source and writes
+// the edited document to the output.
+func applyScript(normalizer *parser.Normalizer) {
+	f, err := os.Open(scriptPath)
+	if err != nil {
+		fail("can't access edit script %s error: %v", scriptPath, err.Error())
+	}
+	script, err := xdiff.NewJSONDecoder(f).Decode()
+	f.Close()
+	if err != nil {
+		fail("failed to read edit script %s error: %v", scriptPath, err.Error())
+	}
+	left, err := newParser().ParseFile(leftSource)
+	if err == nil && normalizer != nil {
+		err = normalizer.Prepare(left)
+	}
+	if err != nil {
+		fail("failed to parse left file %s error: %v", leftSource, err.Error())
+	}
+	if err := xdiff.Apply(left, script); err != nil {
+		fail("failed to apply edit script %s error: %v", scriptPath, err.Error())
+	}
+	out := createOutput()
+	if err := xtree.NewXMLEncoder(out).Encode(left); err != nil {
+		fail("failed to generate output error: %v", err.Error())
+	}
+	if err := out.Close(); err != nil {
+		fail("failed to write output error: %v", err.Error())
+	}
+}
+
+// createOutput returns the output file set by the flags or STDOUT.
+func createOutput() *os.File {
+	if output == "" {
+		return os.Stdout
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		fail("could not create output file: %v", err)
+	}
+	return out
+}
+
+// newNormalizer returns the normalizer set by the flags, or nil if values
+// are not normalized.
+func newNormalizer() *parser.Normalizer {
+	if len(normalize) == 0 && len(normalizePaths) == 0 {
+		return nil
+	}
+	n, err := parser.ParseNormalization(normalize...)
+	if err != nil {
+		fail("%v", err)
+	}
+	normalizer := parser.NewNormalizer(n)
+	for _, np := range normalizePaths {
+		i := strings.LastIndex(np, "=")
+		if i <= 0 || i == len(np)-1 {
+			fail("invalid normalization %q, expected path=normalizations", np)
+		}
+		n, err := parser.ParseNormalization(strings.Split(np[i+1:], "+")...)
+		if err != nil {
+			fail("%v", err)
+		}
+		normalizer.Set(np[:i], n)
+	}
+	return normalizer
+}
+
+// newParser returns the parser selected by the flags.
+func newParser() parser.Parser {
+	p, err := parser.Lookup(parserName)
+	if err != nil {
+		fail("%v", err)
+	}
+	return p
+}
+
+// listFlag collects values of the flag that can be repeated or
+// given as comma separated list.
+type listFlag []string
+
+func (lf *listFlag) String() string {
+	return strings.Join(*lf, ",")
+}
+
+func (lf *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*lf = append(*lf, v)
+		}
+	}
+	return nil
+}
+
 func fail(msg string, params ...interface{}) {
 	fmt.Fprintf(os.Stderr, msg+"\n", params...)
 	os.Exit(1)
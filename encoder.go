@@ -3,10 +3,19 @@ package xdiff
 import (
 	"fmt"
 	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/ajankovic/xdiff/xtree"
 )
 
 // TextEncoder knows how to convert edit script to plain text.
 type TextEncoder struct {
+	// LeftFile and RightFile are the sources of the compared xtrees. When
+	// the nodes have positions every delta is prefixed by the file:line:col
+	// of the changed node.
+	LeftFile, RightFile string
+
 	w io.Writer
 }
 
@@ -16,7 +25,10 @@ func (pte *TextEncoder) Encode(deltas []Delta) error {
 		fmt.Fprint(pte.w, "No difference.\n")
 	}
 	for _, d := range deltas {
-		if d.Operation == Update {
+		if loc := deltaLocation(d, pte.LeftFile, pte.RightFile); loc != "" {
+			fmt.Fprintf(pte.w, "%s: ", loc)
+		}
+		if d.Operation == Update || d.Operation == Move || d.Operation == Rename {
 			fmt.Fprintf(pte.w, "%s('%s'->'%s')\n", d.Operation, d.Subject, d.Object)
 			continue
 		}
@@ -27,5 +39,43 @@ func (pte *TextEncoder) Encode(deltas []Delta) error {
 
 // NewTextEncoder creates new text encoder.
 func NewTextEncoder(w io.Writer) *TextEncoder {
-	return &TextEncoder{w}
+	return &TextEncoder{w: w}
+}
+
+// deltaLocation returns file:line:col of the node changed by the delta. Deleted
+// nodes are located in the left file and the rest of them in the right one.
+// Location is empty if the node has no position.
+func deltaLocation(d Delta, left, right string) string {
+	if d.Operation == Delete || d.Operation == DeleteSubtree {
+		if d.Left.Node == nil {
+			return ""
+		}
+		return location(left, d.Left.Path, d.Left.Node.Start)
+	}
+	if d.Right.Node == nil {
+		return ""
+	}
+	return location(right, d.Right.Path, d.Right.Node.Start)
+}
+
+// location returns file:line:col of the position of the node at the path in
+// the xtree parsed from the source. Directory and document steps of the path
+// are resolved to the file in the source directory.
+func location(source, path string, pos xtree.Position) string {
+	if !pos.IsValid() {
+		return ""
+	}
+	file := source
+	for _, step := range strings.Split(path, "/") {
+		for _, prefix := range []string{"directory(", "document("} {
+			end := strings.LastIndex(step, ")")
+			if strings.HasPrefix(step, prefix) && end > len(prefix) {
+				file = filepath.Join(file, step[len(prefix):end])
+			}
+		}
+	}
+	if file == "" {
+		return pos.String()
+	}
+	return file + ":" + pos.String()
 }
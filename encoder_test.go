@@ -0,0 +1,55 @@
+package xdiff
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ajankovic/xdiff/parser"
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+func TestTextEncoderLocations(t *testing.T) {
+	left, err := parser.New().ParseBytes([]byte("<root>\n  <a>1</a>\n  <b/>\n</root>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	right, err := parser.New().ParseBytes([]byte("<root>\n  <a>2</a>\n  <c/>\n</root>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := Compare(left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := NewTextEncoder(&buf)
+	enc.LeftFile, enc.RightFile = "left.xml", "right.xml"
+	if err := enc.Encode(script); err != nil {
+		t.Fatal(err)
+	}
+	want := "right.xml:2:6: Update('" + script[0].Subject.String() + "'->'" + script[0].Object.String() + "')\n" +
+		"left.xml:3:3: Delete('" + script[1].Subject.String() + "')\n" +
+		"right.xml:3:3: Insert('" + script[2].Subject.String() + "')\n"
+	if buf.String() != want {
+		t.Errorf("Encode() =\n%s, want\n%s", buf.String(), want)
+	}
+}
+
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		source string
+		path   string
+		pos    xtree.Position
+		want   string
+	}{
+		{"a.xml", "/root[1]/@id", xtree.Position{Offset: 7, Line: 1, Column: 8}, "a.xml:1:8"},
+		{"", "/root[1]", xtree.Position{Line: 2, Column: 1}, "2:1"},
+		{"a.xml", "/root[1]", xtree.Position{}, ""},
+		{"dir", "/directory(b)[1]/document(c.xml)[1]/root[1]", xtree.Position{Line: 3, Column: 2}, "dir/b/c.xml:3:2"},
+	}
+	for _, tt := range tests {
+		if got := location(tt.source, tt.path, tt.pos); got != tt.want {
+			t.Errorf("location(%q, %q, %v) = %q, want %q", tt.source, tt.path, tt.pos, got, tt.want)
+		}
+	}
+}
@@ -1 +1,5 @@
 module github.com/ajankovic/xdiff
+
+go 1.25.0
+
+require golang.org/x/text v0.40.0
@@ -0,0 +1,206 @@
+package xdiff
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// HTMLEncoder knows how to convert edit script to the self-contained HTML
+// report. Report starts with the number of changes per operation, followed
+// by the collapsible tree of the right document with the deleted nodes merged
+// back. Changed nodes are highlighted and only the subtrees with changes are
+// expanded. Directories are shown as the trees of their files.
+type HTMLEncoder struct {
+	// Title of the report.
+	Title string
+
+	w     *bufio.Writer
+	right *xtree.Node
+}
+
+// NewHTMLEncoder creates new HTML report encoder for the edit scripts
+// generated for the right xtree.
+func NewHTMLEncoder(w io.Writer, right *xtree.Node) *HTMLEncoder {
+	return &HTMLEncoder{
+		Title: "xdiff report",
+		w:     bufio.NewWriter(w),
+		right: right,
+	}
+}
+
+const htmlStyle = `body{font-family:sans-serif;margin:2em}
+table{border-collapse:collapse;margin-bottom:2em}
+th,td{border:1px solid #ccc;padding:.3em .8em;text-align:center}
+.tree{font-family:monospace}.tree .leaf,.tree summary{white-space:pre-wrap}
+.tree details,.tree .leaf{margin-left:1.5em}
+.tree summary{margin-left:-1.5em;cursor:pointer}
+.file{font-weight:bold}.name{color:#22863a}.attr{color:#6f42c1}.comment{color:#6a737d}
+.insert{background:#e6ffed}.delete{background:#ffeef0}
+.update{background:#fff5b1}.move{background:#e7f3ff}
+.reorder{background:#f5f0ff}.rename{background:#fff1e5}
+del{background:#fdb8c0}ins{background:#acf2bd;text-decoration:none}
+`
+
+// Encode sends the HTML report to the stream.
+func (he *HTMLEncoder) Encode(deltas []Delta) error {
+	merged, err := mergeTree(he.right.Clone(), deltas)
+	if err != nil {
+		return err
+	}
+	title := html.EscapeString(he.Title)
+	fmt.Fprintf(he.w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>\n%s</style>\n</head>\n<body>\n<h1>%s</h1>\n", title, htmlStyle, title)
+
+	counts := make(map[Operation]int)
+	for _, d := range deltas {
+		counts[d.Operation]++
+	}
+	he.w.WriteString("<table class=\"summary\">\n<tr>")
+	for op := Insert; op <= Rename; op++ {
+		fmt.Fprintf(he.w, "<th class=\"%s\">%s</th>", htmlClass(op), op)
+	}
+	he.w.WriteString("<th>Total</th></tr>\n<tr>")
+	for op := Insert; op <= Rename; op++ {
+		fmt.Fprintf(he.w, "<td>%d</td>", counts[op])
+	}
+	fmt.Fprintf(he.w, "<td>%d</td></tr>\n</table>\n", len(deltas))
+
+	// Subtrees are expanded if they contain any change.
+	changed := make(map[*xtree.Node]bool)
+	for _, n := range merged.annotated {
+		for ; n != nil && !changed[n]; n = n.Parent {
+			changed[n] = true
+		}
+	}
+	he.w.WriteString("<div class=\"tree\">\n")
+	for ch := merged.root.FirstChild; ch != nil; ch = ch.NextSibling {
+		he.writeNode(merged, ch, changed)
+	}
+	he.w.WriteString("</div>\n</body>\n</html>\n")
+	return he.w.Flush()
+}
+
+// htmlClass returns the class of the nodes changed by the operation.
+func htmlClass(op Operation) string {
+	switch op {
+	case Insert, InsertSubtree:
+		return "insert"
+	case Delete, DeleteSubtree:
+		return "delete"
+	}
+	return strings.ToLower(op.String())
+}
+
+// writeNode writes the subtree rooted at n.
+func (he *HTMLEncoder) writeNode(merged *mergedTree, n *xtree.Node, changed map[*xtree.Node]bool) {
+	if n.Type == xtree.Attribute {
+		return
+	}
+	annotations := merged.annotations[n]
+	class, title := htmlAnnotations(annotations)
+	container := n.Type == xtree.Element || n.Type == xtree.Document || n.Type == xtree.Directory
+	if !container || firstContent(n) == nil {
+		fmt.Fprintf(he.w, "<div class=\"leaf%s\"%s>%s</div>\n", class, title, he.nodeHTML(merged, n, annotations))
+		return
+	}
+	open := ""
+	if changed[n] {
+		open = " open"
+	}
+	if class != "" {
+		class = " class=\"" + strings.TrimSpace(class) + "\""
+	}
+	fmt.Fprintf(he.w, "<details%s%s%s><summary>%s</summary>\n",
+		class, title, open, he.nodeHTML(merged, n, annotations))
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		he.writeNode(merged, ch, changed)
+	}
+	if n.Type == xtree.Element {
+		fmt.Fprintf(he.w, "<span class=\"name\">&lt;/%s&gt;</span>\n", html.EscapeString(string(n.Name)))
+	}
+	he.w.WriteString("</details>\n")
+}
+
+// nodeHTML returns HTML of the node itself, without its children except for
+// the attributes of the element.
+func (he *HTMLEncoder) nodeHTML(merged *mergedTree, n *xtree.Node, annotations []annotation) string {
+	value := html.EscapeString(string(n.Value))
+	if old, ok := annotationAttr(annotations, "old"); ok {
+		value = "<del>" + html.EscapeString(old) + "</del><ins>" + value + "</ins>"
+	}
+	name := html.EscapeString(string(n.Name))
+	if old, ok := annotationAttr(annotations, "old-name"); ok {
+		name = "<del>" + html.EscapeString(old) + "</del><ins>" + name + "</ins>"
+	}
+	switch n.Type {
+	case xtree.Element:
+		var b strings.Builder
+		fmt.Fprintf(&b, "<span class=\"name\">&lt;%s</span>", name)
+		for ch := n.FirstChild; ch != nil && ch.Type == xtree.Attribute; ch = ch.NextSibling {
+			class, title := htmlAnnotations(merged.annotations[ch])
+			fmt.Fprintf(&b, " <span class=\"attr%s\"%s>%s</span>", class, title, he.nodeHTML(merged, ch, merged.annotations[ch]))
+		}
+		if firstContent(n) == nil {
+			b.WriteString("<span class=\"name\">/&gt;</span>")
+		} else {
+			b.WriteString("<span class=\"name\">&gt;</span>")
+		}
+		return b.String()
+	case xtree.Attribute:
+		return name + "=&quot;" + value + "&quot;"
+	case xtree.Comment:
+		return "<span class=\"comment\">&lt;!--" + value + "--&gt;</span>"
+	case xtree.ProcInstr:
+		return "&lt;?" + name + " " + value + "?&gt;"
+	case xtree.Doctype:
+		return "&lt;!DOCTYPE " + value + "&gt;"
+	case xtree.Declaration:
+		var b strings.Builder
+		b.WriteString("&lt;?xml")
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			b.WriteString(" " + he.nodeHTML(merged, ch, merged.annotations[ch]))
+		}
+		b.WriteString("?&gt;")
+		return b.String()
+	case xtree.CData:
+		return "&lt;![CDATA[" + value + "]]&gt;"
+	case xtree.Directory:
+		return "<span class=\"file\">" + name + "/</span>"
+	case xtree.Document, xtree.NotXML:
+		return "<span class=\"file\">" + name + "</span>"
+	}
+	return value
+}
+
+// htmlAnnotations returns the class and the title attribute of the changed
+// node.
+func htmlAnnotations(annotations []annotation) (string, string) {
+	var class, notes []string
+	for _, a := range annotations {
+		class = append(class, a.op)
+		if from, ok := annotationAttr([]annotation{a}, "from"); ok {
+			notes = append(notes, "moved from "+from)
+		}
+	}
+	if len(class) == 0 {
+		return "", ""
+	}
+	title := " title=\"" + html.EscapeString(strings.Join(append(class, notes...), ", ")) + "\""
+	return " " + strings.Join(class, " "), title
+}
+
+// annotationAttr returns the value of the annotation's attribute.
+func annotationAttr(annotations []annotation, name string) (string, bool) {
+	for _, a := range annotations {
+		for _, attr := range a.attrs {
+			if attr[0] == name {
+				return attr[1], true
+			}
+		}
+	}
+	return "", false
+}
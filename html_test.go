@@ -0,0 +1,76 @@
+package xdiff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+func TestHTMLEncoder(t *testing.T) {
+	left := doc("", el("root", attr("id", "1"), el("a", dat("x < y")), el("b", el("c")), el("d")))
+	right := doc("", el("root", attr("id", "2"), el("a", dat("x > y")), el("b", el("c"), el("e")), el("f", attr("k", "v"))))
+	xtree.Prepare(left)
+	xtree.Prepare(right)
+	script, err := Compare(left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := NewHTMLEncoder(&buf, right)
+	enc.Title = "left.xml <> right.xml"
+	if err := enc.Encode(script); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"<title>left.xml &lt;&gt; right.xml</title>",
+		`<th class="insert">Insert</th>`,
+		"<tr><td>1</td><td>2</td><td>1</td><td>1</td><td>0</td><td>0</td><td>0</td><td>0</td><td>5</td></tr>",
+		`<span class="attr update" title="update">id=&quot;<del>1</del><ins>2</ins>&quot;</span>`,
+		`<div class="leaf update" title="update"><del>x &lt; y</del><ins>x &gt; y</ins></div>`,
+		`<div class="leaf insert" title="insert"><span class="name">&lt;e</span><span class="name">/&gt;</span></div>`,
+		`<div class="leaf delete" title="delete"><span class="name">&lt;d</span><span class="name">/&gt;</span></div>`,
+		`<details open><summary><span class="name">&lt;b</span>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Encode() =\n%s, want it to contain %s", out, want)
+		}
+	}
+	for _, external := range []string{"<link", "<script", "src=", "href="} {
+		if strings.Contains(out, external) {
+			t.Errorf("Encode() =\n%s, want no external assets but found %s", out, external)
+		}
+	}
+}
+
+func TestHTMLEncoderDirectory(t *testing.T) {
+	left := dir("docs",
+		doc("a.xml", el("root", el("a", dat("1")), el("b", dat("2")))),
+		doc("b.xml", el("root", el("b", dat("1")))))
+	right := dir("docs",
+		doc("a.xml", el("root", el("a", dat("1")), el("b", dat("2")), el("c"))),
+		doc("c.xml", el("other", el("d", dat("2")))))
+	xtree.Prepare(left)
+	xtree.Prepare(right)
+	script, err := Compare(left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := NewHTMLEncoder(&buf, right).Encode(script); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<details open><summary><span class="file">a.xml</span></summary>`,
+		`<div class="leaf insert" title="insert"><span class="name">&lt;c</span><span class="name">/&gt;</span></div>`,
+		`<details class="delete" title="delete" open><summary><span class="file">b.xml</span></summary>`,
+		`<details class="insert" title="insert" open><summary><span class="file">c.xml</span></summary>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Encode() =\n%s, want it to contain %s", out, want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package xdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// jsonDelta is the JSON form of the delta. Type, name, value, path and
+// parent describe the changed node, the node of the right xtree for
+// insertions and the node of the left xtree otherwise. Location is the
+// file:line:col of the changed node as printed by the TextEncoder.
+type jsonDelta struct {
+	Operation string   `json:"operation"`
+	Type      string   `json:"type"`
+	Name      string   `json:"name,omitempty"`
+	Value     string   `json:"value,omitempty"`
+	Path      string   `json:"path"`
+	Parent    string   `json:"parent"`
+	Location  string   `json:"location,omitempty"`
+	Left      *jsonRef `json:"left,omitempty"`
+	Right     *jsonRef `json:"right,omitempty"`
+}
+
+// jsonRef is the JSON form of the node reference.
+type jsonRef struct {
+	Path   string    `json:"path,omitempty"`
+	Parent string    `json:"parent,omitempty"`
+	Index  int       `json:"index"`
+	Node   *jsonNode `json:"node,omitempty"`
+}
+
+// jsonNode is the JSON form of the detached node.
+type jsonNode struct {
+	Type     string     `json:"type"`
+	Name     string     `json:"name,omitempty"`
+	Value    string     `json:"value,omitempty"`
+	Children []jsonNode `json:"children,omitempty"`
+}
+
+// JSONEncoder knows how to convert edit script to JSON. Every delta is
+// written as a separate JSON object on its own line.
+type JSONEncoder struct {
+	// LeftFile and RightFile are the sources of the compared xtrees used
+	// in the locations of the changed nodes.
+	LeftFile, RightFile string
+
+	enc *json.Encoder
+}
+
+// NewJSONEncoder creates new JSON encoder.
+func NewJSONEncoder(w io.Writer) *JSONEncoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &JSONEncoder{enc: enc}
+}
+
+// Encode sends edit script in JSON to the stream.
+func (je *JSONEncoder) Encode(deltas []Delta) error {
+	for _, d := range deltas {
+		jd := jsonDelta{
+			Operation: d.Operation.String(),
+			Location:  deltaLocation(d, je.LeftFile, je.RightFile),
+			Left:      encodeRef(d.Left),
+			Right:     encodeRef(d.Right),
+		}
+		ref := d.Left
+		if d.Operation == Insert || d.Operation == InsertSubtree {
+			ref = d.Right
+		}
+		if ref.Node != nil {
+			jd.Type = ref.Node.Type.String()
+			jd.Name = string(ref.Node.Name)
+			jd.Value = string(ref.Node.Value)
+		}
+		jd.Path = ref.Path
+		if i := strings.LastIndex(ref.Path, "/"); i > 0 {
+			jd.Parent = ref.Path[:i]
+		} else if i == 0 && ref.Path != "/" {
+			jd.Parent = "/"
+		}
+		if err := je.enc.Encode(jd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeRef returns JSON form of the reference or nil if it's empty.
+func encodeRef(ref NodeRef) *jsonRef {
+	if ref == (NodeRef{}) {
+		return nil
+	}
+	jr := &jsonRef{Path: ref.Path, Parent: ref.Parent, Index: ref.Index}
+	if ref.Node != nil {
+		node := encodeNode(ref.Node)
+		jr.Node = &node
+	}
+	return jr
+}
+
+// encodeNode returns JSON form of the subtree rooted at n.
+func encodeNode(n *xtree.Node) jsonNode {
+	jn := jsonNode{
+		Type:  n.Type.String(),
+		Name:  string(n.Name),
+		Value: string(n.Value),
+	}
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		jn.Children = append(jn.Children, encodeNode(ch))
+	}
+	return jn
+}
+
+// JSONDecoder knows how to read edit script written by the JSONEncoder.
+type JSONDecoder struct {
+	dec *json.Decoder
+}
+
+// NewJSONDecoder creates new JSON decoder.
+func NewJSONDecoder(r io.Reader) *JSONDecoder {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	return &JSONDecoder{dec}
+}
+
+// Decode reads edit script from the stream until its end. Decoded deltas
+// address nodes only by their paths, they can be passed to the Apply.
+func (jd *JSONDecoder) Decode() ([]Delta, error) {
+	var deltas []Delta
+	for {
+		var d jsonDelta
+		err := jd.dec.Decode(&d)
+		if err == io.EOF {
+			return deltas, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xdiff: invalid JSON delta: %v", err)
+		}
+		delta := Delta{}
+		if delta.Operation, err = parseOperation(d.Operation); err != nil {
+			return nil, err
+		}
+		if delta.Left, err = decodeRef(d.Left); err != nil {
+			return nil, err
+		}
+		if delta.Right, err = decodeRef(d.Right); err != nil {
+			return nil, err
+		}
+		deltas = append(deltas, delta)
+	}
+}
+
+// decodeRef returns the reference from its JSON form.
+func decodeRef(jr *jsonRef) (NodeRef, error) {
+	if jr == nil {
+		return NodeRef{}, nil
+	}
+	ref := NodeRef{Path: jr.Path, Parent: jr.Parent, Index: jr.Index}
+	if jr.Node != nil {
+		node, err := decodeNode(*jr.Node)
+		if err != nil {
+			return NodeRef{}, err
+		}
+		ref.Node = node
+	}
+	return ref, nil
+}
+
+// decodeNode returns the subtree from its JSON form.
+func decodeNode(jn jsonNode) (*xtree.Node, error) {
+	t, err := parseNodeType(jn.Type)
+	if err != nil {
+		return nil, err
+	}
+	n := xtree.NewNode(t)
+	if jn.Name != "" {
+		n.Name = []byte(jn.Name)
+	}
+	if jn.Value != "" {
+		n.Value = []byte(jn.Value)
+	}
+	for _, jch := range jn.Children {
+		ch, err := decodeNode(jch)
+		if err != nil {
+			return nil, err
+		}
+		n.AppendChild(ch)
+	}
+	return n, nil
+}
+
+// parseOperation returns operation by its name.
+func parseOperation(name string) (Operation, error) {
+	for op := Insert; op <= Rename; op++ {
+		if op.String() == name {
+			return op, nil
+		}
+	}
+	return 0, fmt.Errorf("xdiff: unknown operation %q", name)
+}
+
+// parseNodeType returns node type by its name.
+func parseNodeType(name string) (xtree.NodeType, error) {
+	for t := xtree.NotXML; t <= xtree.ProcInstr; t++ {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("xdiff: unknown node type %q", name)
+}
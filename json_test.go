@@ -0,0 +1,95 @@
+package xdiff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+func TestJSONEncoder(t *testing.T) {
+	left := doc("", el("root", el("a", dat("1"))))
+	right := doc("", el("root", el("a", dat("2")), el("b", attr("id", "1"))))
+	xtree.Prepare(left)
+	xtree.Prepare(right)
+	script, err := Compare(left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := NewJSONEncoder(&buf).Encode(script); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		`{"operation":"Update","type":"Data","value":"1","path":"/root[1]/a[1]/text()[1]","parent":"/root[1]/a[1]",` +
+			`"left":{"path":"/root[1]/a[1]/text()[1]","parent":"/root[1]/a[1]","index":0,"node":{"type":"Data","value":"1"}},` +
+			`"right":{"path":"/root[1]/a[1]/text()[1]","parent":"/root[1]/a[1]","index":0,"node":{"type":"Data","value":"2"}}}`,
+		`{"operation":"InsertSubtree","type":"Element","name":"b","path":"/root[1]/b[1]","parent":"/root[1]",` +
+			`"left":{"parent":"/root[1]","index":1},` +
+			`"right":{"path":"/root[1]/b[1]","parent":"/root[1]","index":1,"node":{"type":"Element","name":"b","children":[{"type":"Attribute","name":"id","value":"1"}]}}}`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Encode() = %d lines, want %d:\n%s", len(lines), len(want), buf.String())
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("Encode() line %d =\n%s, want\n%s", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestJSONDecoder(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		t.Run(fmt.Sprintf("Seed %d", seed), func(t *testing.T) {
+			c := NewComparer()
+			c.DetectRenames = true
+			left, right := randomTrees(seed)
+			script, err := c.Compare(left, right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := NewJSONEncoder(&buf).Encode(script); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := NewJSONDecoder(&buf).Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decoded) != len(script) {
+				t.Fatalf("Decode() = %d deltas, want %d", len(decoded), len(script))
+			}
+			left, right = randomTrees(seed)
+			if err := Apply(left, decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(canonicalHash(left, false), canonicalHash(right, false)) {
+				l, _ := xtree.TextString(left)
+				r, _ := xtree.TextString(right)
+				t.Fatalf("Apply(left, %v) =\n%s, want\n%s", decoded, l, r)
+			}
+		})
+	}
+}
+
+func TestJSONDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"Invalid JSON", `{"operation":`},
+		{"Unknown field", `{"operation":"Delete","extra":1}`},
+		{"Unknown operation", `{"operation":"Replace"}`},
+		{"Unknown node type", `{"operation":"Insert","right":{"node":{"type":"Text"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewJSONDecoder(strings.NewReader(tt.input)).Decode(); err == nil {
+				t.Error("Decode() succeeded, want error")
+			}
+		})
+	}
+}
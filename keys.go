@@ -0,0 +1,181 @@
+package xdiff
+
+import (
+	"strings"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// KeyPolicy identifies sibling nodes by their keys. Nodes with equal keys
+// are always matched and nodes with different keys are never matched.
+type KeyPolicy interface {
+	// Key returns the key of the node or false if the node doesn't have one.
+	Key(n *xtree.Node) (string, bool)
+}
+
+// SignatureKeys is a KeyPolicy which declares keys of the nodes by their
+// signatures. Signatures are set the same way as for SignaturePolicy. Key is
+// a path relative to the node, where the last step is either the attribute
+// name prefixed with "@", like "@id", or the name of the child element whose
+// text is used as the key, like "artifactId" or "parent/artifactId". Steps
+// match the names the same way as signatures do.
+type SignatureKeys struct {
+	keys map[string][]string
+}
+
+// NewSignatureKeys creates empty key policy.
+func NewSignatureKeys() *SignatureKeys {
+	return &SignatureKeys{keys: make(map[string][]string)}
+}
+
+// Set declares the key of the nodes with the signature. When more than one
+// key path is given, the values of all of them form the key.
+func (sk *SignatureKeys) Set(signature string, key ...string) {
+	if sk.keys == nil {
+		sk.keys = make(map[string][]string)
+	}
+	sk.keys[signature] = key
+}
+
+// Key implements KeyPolicy. Node has the key if at least one of the
+// declared key paths is found, missing ones are taken as empty.
+func (sk *SignatureKeys) Key(n *xtree.Node) (string, bool) {
+	sig, ok := findSignature(n, func(sig string) bool {
+		_, ok := sk.keys[sig]
+		return ok
+	})
+	if !ok {
+		return "", false
+	}
+	paths := sk.keys[sig]
+	values := make([]string, len(paths))
+	found := false
+	for i, path := range paths {
+		if v, ok := keyValue(n, path); ok {
+			values[i] = v
+			found = true
+		}
+	}
+	return strings.Join(values, "\x00"), found
+}
+
+// keyValue finds value of the key path relative to the node.
+func keyValue(n *xtree.Node, path string) (string, bool) {
+	steps := splitSteps(path)
+	for i, step := range steps {
+		last := i == len(steps)-1
+		if last && strings.HasPrefix(step, "@") {
+			if attr := findChild(n, xtree.Attribute, step[1:]); attr != nil {
+				return string(attr.Value), true
+			}
+			return "", false
+		}
+		if n = findChild(n, xtree.Element, step); n == nil {
+			return "", false
+		}
+	}
+	var text []byte
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		if ch.Type == xtree.Data || ch.Type == xtree.CData {
+			text = append(text, ch.Value...)
+		}
+	}
+	return string(text), true
+}
+
+// splitSteps splits the key path by the slashes which are not inside the
+// namespace URIs of the {uri}local names.
+func splitSteps(path string) []string {
+	var steps []string
+	depth, start := 0, 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				steps = append(steps, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(steps, path[start:])
+}
+
+// findChild returns the first child of the type with the name. Children are
+// matched the same way as the nodes of the signatures, by expanded names
+// first, then by prefixed names and by local names.
+func findChild(n *xtree.Node, t xtree.NodeType, name string) *xtree.Node {
+	for _, form := range []func(*xtree.Node) []byte{
+		(*xtree.Node).ExpandedName,
+		func(ch *xtree.Node) []byte { return ch.Name },
+		(*xtree.Node).LocalName,
+	} {
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			if ch.Type == t && string(form(ch)) == name {
+				return ch
+			}
+		}
+	}
+	return nil
+}
+
+// keysDiffer returns true if both nodes have keys and they are different,
+// in which case the nodes must not be matched.
+func (c *Comparer) keysDiffer(l, r *xtree.Node) bool {
+	if c.Keys == nil {
+		return false
+	}
+	lk, lok := c.Keys.Key(l)
+	rk, rok := c.Keys.Key(r)
+	return lok && rok && lk != rk
+}
+
+// keyedPairs pairs nodes with the same signature and equal keys regardless
+// of their position and returns the nodes that were left unpaired.
+func (c *Comparer) keyedPairs(lefts, rights []*xtree.Node) ([]nodePair, []*xtree.Node, []*xtree.Node) {
+	if c.Keys == nil {
+		return nil, lefts, rights
+	}
+	type sigKey struct {
+		signature string
+		key       string
+	}
+	keyed := make(map[sigKey][]*xtree.Node)
+	for _, r := range rights {
+		if k, ok := c.Keys.Key(r); ok {
+			sk := sigKey{string(r.Signature), k}
+			keyed[sk] = append(keyed[sk], r)
+		}
+	}
+	if len(keyed) == 0 {
+		return nil, lefts, rights
+	}
+	var pairs []nodePair
+	var restLeft, restRight []*xtree.Node
+	paired := make(map[*xtree.Node]struct{})
+	for _, l := range lefts {
+		k, ok := c.Keys.Key(l)
+		if !ok {
+			restLeft = append(restLeft, l)
+			continue
+		}
+		sk := sigKey{string(l.Signature), k}
+		rs := keyed[sk]
+		if len(rs) == 0 {
+			restLeft = append(restLeft, l)
+			continue
+		}
+		pairs = append(pairs, nodePair{l, rs[0]})
+		paired[rs[0]] = struct{}{}
+		keyed[sk] = rs[1:]
+	}
+	for _, r := range rights {
+		if _, ok := paired[r]; !ok {
+			restRight = append(restRight, r)
+		}
+	}
+	return pairs, restLeft, restRight
+}
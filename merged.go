@@ -0,0 +1,228 @@
+package xdiff
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// MergedNamespace is the namespace of the annotations in merged document.
+const MergedNamespace = "https://github.com/ajankovic/xdiff"
+
+// MergedEncoder knows how to convert edit script to the right document
+// annotated with the changes. Deleted nodes are merged back into the
+// document at the positions they had in the left document.
+//
+// Changed elements get the xdiff:op attribute listing their operations,
+// with xdiff:old-name for the renamed and xdiff:from for the moved ones.
+// Changed text, comments and processing instructions are wrapped into
+// xdiff:insert, xdiff:delete, xdiff:update, xdiff:move, xdiff:reorder and
+// xdiff:rename elements. Changes of the attributes are listed as the
+// xdiff:attribute elements at the start of their element's contents.
+type MergedEncoder struct {
+	enc   *xtree.XMLEncoder
+	right *xtree.Node
+}
+
+// NewMergedEncoder creates new merged document encoder for the edit scripts
+// generated for the right xtree.
+func NewMergedEncoder(w io.Writer, right *xtree.Node) *MergedEncoder {
+	return &MergedEncoder{xtree.NewXMLEncoder(w), right}
+}
+
+// Indent sets the encoder to generate XML in which each element begins on
+// a new indented line.
+func (me *MergedEncoder) Indent(indent string) {
+	me.enc.Indent(indent)
+}
+
+// Encode sends the merged document to the stream. Directories can't be
+// merged into a single document so their files have to be compared one by
+// one.
+func (me *MergedEncoder) Encode(deltas []Delta) error {
+	if me.right.Type == xtree.Directory {
+		return fmt.Errorf("xdiff: merged document can't be made of directory %s, compare its files instead", me.right.Name)
+	}
+	merged, err := mergeTree(me.right.Clone(), deltas)
+	if err != nil {
+		return err
+	}
+	for _, n := range merged.annotated {
+		for _, a := range merged.annotations[n] {
+			mark(n, a)
+		}
+	}
+	for root := merged.root.FirstChild; root != nil; root = root.NextSibling {
+		if root.Type == xtree.Element {
+			root.InsertBefore(xtree.NewAttribute([]byte("xmlns:xdiff"), []byte(MergedNamespace)), root.FirstChild)
+		}
+	}
+	return me.enc.Encode(merged.root)
+}
+
+// annotation is the change made to the node of the merged document.
+type annotation struct {
+	op    string
+	attrs [][2]string
+	// path of the changed node, in the left xtree for the deleted nodes
+	// and in the right xtree otherwise.
+	path string
+}
+
+// mergedTree is the right xtree with the deleted nodes merged back, along
+// with the changes made to its nodes.
+type mergedTree struct {
+	root        *xtree.Node
+	annotations map[*xtree.Node][]annotation
+	// annotated are the changed nodes in the order of the deltas, with
+	// the deleted nodes at the end.
+	annotated []*xtree.Node
+}
+
+// annotate adds the change of the node.
+func (mt *mergedTree) annotate(n *xtree.Node, a annotation) {
+	if _, ok := mt.annotations[n]; !ok {
+		mt.annotated = append(mt.annotated, n)
+	}
+	mt.annotations[n] = append(mt.annotations[n], a)
+}
+
+// mergeTree merges deleted nodes into the right xtree and annotates its
+// nodes with the changes of the deltas. Deleted documents and directories
+// are merged back into their directories.
+func mergeTree(right *xtree.Node, deltas []Delta) (*mergedTree, error) {
+	if right.Type != xtree.Document && right.Type != xtree.Directory {
+		return nil, fmt.Errorf("xdiff: merged document can't be made of %s", right.Type)
+	}
+	merged := &mergedTree{
+		root:        right,
+		annotations: make(map[*xtree.Node][]annotation),
+	}
+	// Paths address the nodes of the right xtree so they are all resolved
+	// before the deleted nodes are added.
+	deleted := make(map[*xtree.Node][]placement)
+	deletedPaths := make(map[*xtree.Node]string)
+	var parents []*xtree.Node
+	for _, d := range deltas {
+		if d.Operation == Delete || d.Operation == DeleteSubtree {
+			parent, err := right.Find(d.Right.Parent)
+			if err != nil {
+				return nil, err
+			}
+			if d.Left.Node == nil {
+				return nil, fmt.Errorf("xdiff: %s of %q without deleted node", d.Operation, d.Left.Path)
+			}
+			if _, ok := deleted[parent]; !ok {
+				parents = append(parents, parent)
+			}
+			node := d.Left.Node.Clone()
+			deletedPaths[node] = d.Left.Path
+			deleted[parent] = append(deleted[parent], placement{node, d.Right.Index})
+			continue
+		}
+		n, err := right.Find(d.Right.Path)
+		if err != nil {
+			return nil, err
+		}
+		a := annotation{op: strings.ToLower(d.Operation.String()), path: d.Right.Path}
+		switch d.Operation {
+		case Insert, InsertSubtree:
+			a.op = "insert"
+		case Update:
+			if d.Left.Node == nil {
+				return nil, fmt.Errorf("xdiff: %s of %q without old node", d.Operation, d.Left.Path)
+			}
+			a.attrs = [][2]string{{"old", string(d.Left.Node.Value)}}
+		case Rename:
+			if d.Left.Node == nil {
+				return nil, fmt.Errorf("xdiff: %s of %q without old node", d.Operation, d.Left.Path)
+			}
+			a.attrs = [][2]string{{"old-name", string(d.Left.Node.Name)}}
+		case Move:
+			a.attrs = [][2]string{{"from", d.Left.Path}}
+		}
+		merged.annotate(n, a)
+	}
+	for _, parent := range parents {
+		nodes := deleted[parent]
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].position < nodes[j].position
+		})
+		for _, p := range nodes {
+			if parent.Type != xtree.Element && parent.Type != xtree.Directory {
+				continue
+			}
+			before := childAt(parent, p.position)
+			if p.node.Type == xtree.Attribute || before == nil || before.Type == xtree.Attribute {
+				before = firstContent(parent)
+			}
+			parent.InsertBefore(p.node, before)
+			merged.annotate(p.node, annotation{op: "delete", path: deletedPaths[p.node]})
+		}
+	}
+	return merged, nil
+}
+
+// mark adds the annotation to the node of the merged document. Nodes outside
+// of the elements are not annotated since that would make the document
+// invalid.
+func mark(n *xtree.Node, a annotation) {
+	if n.Type != xtree.Element && (n.Parent == nil || n.Parent.Type != xtree.Element) {
+		return
+	}
+	switch n.Type {
+	case xtree.Element:
+		var op *xtree.Node
+		for ch := n.FirstChild; ch != nil && ch.Type == xtree.Attribute; ch = ch.NextSibling {
+			if string(ch.Name) == "xdiff:op" {
+				op = ch
+			}
+		}
+		if op != nil {
+			op.Value = append(op.Value, " "+a.op...)
+		} else {
+			n.InsertBefore(xtree.NewAttribute([]byte("xdiff:op"), []byte(a.op)), n.FirstChild)
+		}
+		for _, attr := range a.attrs {
+			n.InsertBefore(xtree.NewAttribute([]byte("xdiff:"+attr[0]), []byte(attr[1])), firstContent(n))
+		}
+	case xtree.Attribute:
+		// Attributes can't be annotated so their changes are described by
+		// the elements, and the deleted attribute is dropped.
+		change := xtree.NewElement([]byte("xdiff:attribute"))
+		change.AppendChild(xtree.NewAttribute([]byte("name"), n.Name))
+		change.AppendChild(xtree.NewAttribute([]byte("op"), []byte(a.op)))
+		if a.op == "delete" {
+			change.AppendChild(xtree.NewAttribute([]byte("old"), n.Value))
+		}
+		for _, attr := range a.attrs {
+			change.AppendChild(xtree.NewAttribute([]byte(attr[0]), []byte(attr[1])))
+		}
+		parent := n.Parent
+		if a.op == "delete" {
+			n.Remove()
+		}
+		parent.InsertBefore(change, contentStart(parent))
+	default:
+		wrapper := xtree.NewElement([]byte("xdiff:" + a.op))
+		for _, attr := range a.attrs {
+			wrapper.AppendChild(xtree.NewAttribute([]byte(attr[0]), []byte(attr[1])))
+		}
+		n.Parent.InsertBefore(wrapper, n)
+		n.Remove()
+		wrapper.AppendChild(n)
+	}
+}
+
+// contentStart returns the first child of the element which is neither an
+// attribute nor a description of the attribute change.
+func contentStart(n *xtree.Node) *xtree.Node {
+	ch := firstContent(n)
+	for ch != nil && ch.Type == xtree.Element && string(ch.Name) == "xdiff:attribute" {
+		ch = ch.NextSibling
+	}
+	return ch
+}
@@ -0,0 +1,118 @@
+package xdiff
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+func TestMergedEncoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		left  *xtree.Node
+		right *xtree.Node
+		want  string
+	}{
+		{"Insert",
+			doc("", el("root", el("a"))),
+			doc("", el("root", el("a"), el("b", dat("1")))),
+			`<root xmlns:xdiff="` + MergedNamespace + `"><a></a><b xdiff:op="insert">1</b></root>`},
+		{"Delete",
+			doc("", el("root", el("a", dat("1")), dat("text"), el("b"))),
+			doc("", el("root", el("b"))),
+			`<root xmlns:xdiff="` + MergedNamespace + `"><a xdiff:op="delete">1</a><xdiff:delete>text</xdiff:delete><b></b></root>`},
+		{"Update",
+			doc("", el("root", el("a", dat("1 < 2")))),
+			doc("", el("root", el("a", dat("2 > 1")))),
+			`<root xmlns:xdiff="` + MergedNamespace + `"><a><xdiff:update old="1 &lt; 2">2 &gt; 1</xdiff:update></a></root>`},
+		{"Attributes",
+			doc("", el("root", attr("a", "1"), attr("b", "2"), el("c"))),
+			doc("", el("root", attr("a", "3"), attr("d", "4"), el("c"))),
+			`<root xmlns:xdiff="` + MergedNamespace + `" a="3" d="4">` +
+				`<xdiff:attribute name="a" op="update" old="1"></xdiff:attribute>` +
+				`<xdiff:attribute name="d" op="insert"></xdiff:attribute>` +
+				`<xdiff:attribute name="b" op="delete" old="2"></xdiff:attribute>` +
+				`<c></c></root>`},
+		{"Move",
+			doc("", el("root", el("a", el("c", dat("1"), dat("2"))), el("b"))),
+			doc("", el("root", el("a"), el("b", el("c", dat("1"), dat("2"))))),
+			`<root xmlns:xdiff="` + MergedNamespace + `"><a></a><b><c xdiff:op="move" xdiff:from="/root[1]/a[1]/c[1]">12</c></b></root>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xtree.Prepare(tt.left)
+			xtree.Prepare(tt.right)
+			c := NewComparer()
+			c.DetectMoves = true
+			script, err := c.Compare(tt.left, tt.right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := NewMergedEncoder(&buf, tt.right).Encode(script); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Encode() =\n%s, want\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMergedEncoderWellFormed(t *testing.T) {
+	for seed := int64(0); seed < 100; seed++ {
+		t.Run(fmt.Sprintf("Seed %d", seed), func(t *testing.T) {
+			c := NewComparer()
+			c.Ordered = seed%2 == 0
+			c.DetectRenames = true
+			left, right := randomTrees(seed)
+			script, err := c.Compare(left, right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			enc := NewMergedEncoder(&buf, right)
+			enc.Indent("  ")
+			if err := enc.Encode(script); err != nil {
+				t.Fatal(err)
+			}
+			dec := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+			for {
+				tok, err := dec.Token()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Encode() =\n%s, invalid XML: %v", buf.String(), err)
+				}
+				if el, ok := tok.(xml.StartElement); ok && el.Name.Space == "xdiff" {
+					t.Fatalf("Encode() =\n%s, undeclared namespace", buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestMergedEncoderDirectory(t *testing.T) {
+	left := dir("left", doc("a.xml", el("root", el("a"))))
+	right := dir("right", doc("a.xml", el("root", el("b"))))
+	xtree.Prepare(left)
+	xtree.Prepare(right)
+	script, err := Compare(left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = NewMergedEncoder(&buf, right).Encode(script)
+	if err == nil || !strings.Contains(err.Error(), "compare its files instead") {
+		t.Errorf("Encode() error = %v, want error for directory", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Encode() wrote %q for directory", buf.String())
+	}
+}
@@ -4,9 +4,9 @@ package xdiff
 
 import "strconv"
 
-const _Operation_name = "InsertUpdateDeleteInsertSubtreeDeleteSubtree"
+const _Operation_name = "InsertUpdateDeleteInsertSubtreeDeleteSubtreeReorderMoveRename"
 
-var _Operation_index = [...]uint8{0, 6, 12, 18, 31, 44}
+var _Operation_index = [...]uint8{0, 6, 12, 18, 31, 44, 51, 55, 61}
 
 func (i Operation) String() string {
 	i -= 1
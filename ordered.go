@@ -0,0 +1,288 @@
+package xdiff
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// maxAlignCells limits size of the table used for aligning siblings. When
+// there are too many siblings only the identical ones are matched.
+const maxAlignCells = 1 << 22
+
+// OrderPolicy decides whether order of the node's children is significant.
+type OrderPolicy interface {
+	Ordered(n *xtree.Node) bool
+}
+
+// SignaturePolicy is an OrderPolicy which decides by signature of the parent
+// node. Signatures are set as paths of node names, for example
+// "/project/build/plugins", or as complete node signatures with type suffix
+// like "/project/build/plugins/Element". Names of the namespaced nodes may
+// be given as local names, with their prefixes or as {uri}local.
+type SignaturePolicy struct {
+	// Default is used for nodes with signatures not set in the policy.
+	Default    bool
+	signatures map[string]bool
+}
+
+// NewSignaturePolicy creates policy with children of the nodes with the
+// given signatures ordered and the rest of them unordered.
+func NewSignaturePolicy(ordered ...string) *SignaturePolicy {
+	sp := &SignaturePolicy{signatures: make(map[string]bool)}
+	for _, sig := range ordered {
+		sp.Set(sig, true)
+	}
+	return sp
+}
+
+// Set marks children of the nodes with the signature as ordered or unordered.
+func (sp *SignaturePolicy) Set(signature string, ordered bool) {
+	if sp.signatures == nil {
+		sp.signatures = make(map[string]bool)
+	}
+	sp.signatures[signature] = ordered
+}
+
+// Ordered implements OrderPolicy.
+func (sp *SignaturePolicy) Ordered(n *xtree.Node) bool {
+	sig, ok := findSignature(n, func(sig string) bool {
+		_, ok := sp.signatures[sig]
+		return ok
+	})
+	if !ok {
+		return sp.Default
+	}
+	return sp.signatures[sig]
+}
+
+// findSignature returns the first form of the node signature accepted by
+// the has function. Signature with expanded names is tried first, then the
+// one with prefixed names and the one with local names. Every signature is
+// tried as is and as a path without the node type suffix.
+func findSignature(n *xtree.Node, has func(string) bool) (string, bool) {
+	suffix := "/" + n.Type.String()
+	var tried []string
+	for _, form := range []func() []byte{
+		func() []byte { return n.Signature },
+		n.QualifiedSignature,
+		n.LocalSignature,
+	} {
+		sig := string(form())
+		if slices.Contains(tried, sig) {
+			continue
+		}
+		tried = append(tried, sig)
+		if has(sig) {
+			return sig, true
+		}
+		if path := strings.TrimSuffix(sig, suffix); has(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// isOrdered returns true if order of the node's children is significant.
+func (c *Comparer) isOrdered(n *xtree.Node) bool {
+	if c.OrderPolicy != nil {
+		return c.OrderPolicy.Ordered(n)
+	}
+	return c.Ordered
+}
+
+// alignChildren finds minimum-cost matching between children of the nodes
+// where every child that changed its position costs as much as a move.
+// Identical children are matched first regardless of their position since
+// moving them is cheaper than deleting and inserting them again. Children
+// with equal keys are matched next. The rest of the children are matched
+// by minimum cost regardless of the order, so that changed children can
+// be reordered too, unless aligning them in order is at least as cheap.
+// Attributes are matched regardless of the order. It returns matched pairs
+// along with the number of children that changed their position.
+func (c *Comparer) alignChildren(leftChildren, rightChildren []*xtree.Node, distTbl distTable, sizes sizeTable) ([]nodePair, int) {
+	var leftAttrs, rightAttrs, lefts, rights []*xtree.Node
+	for _, ch := range leftChildren {
+		if ch.Type == xtree.Attribute {
+			leftAttrs = append(leftAttrs, ch)
+		} else {
+			lefts = append(lefts, ch)
+		}
+	}
+	for _, ch := range rightChildren {
+		if ch.Type == xtree.Attribute {
+			rightAttrs = append(rightAttrs, ch)
+		} else {
+			rights = append(rights, ch)
+		}
+	}
+	pinned, restLeft, restRight := identicalPairs(lefts, rights)
+	keyed, restLeft, restRight := c.keyedPairs(restLeft, restRight)
+	pinned = append(pinned, keyed...)
+	aligned := append(pinned[:len(pinned):len(pinned)], c.alignSequence(restLeft, restRight, distTbl, sizes)...)
+	moved := len(outOfOrder(aligned, lefts, rights))
+	matched := append(pinned[:len(pinned):len(pinned)], c.matchUnordered(restLeft, restRight, distTbl, sizes)...)
+	if reorders := len(outOfOrder(matched, lefts, rights)); c.pairsCost(matched, distTbl, sizes)+c.MoveCost*reorders <
+		c.pairsCost(aligned, distTbl, sizes)+c.MoveCost*moved {
+		aligned, moved = matched, reorders
+	}
+	return append(c.matchUnordered(leftAttrs, rightAttrs, distTbl, sizes), aligned...), moved
+}
+
+// pairsCost returns the cost of matching the pairs instead of deleting and
+// inserting their nodes.
+func (c *Comparer) pairsCost(pairs []nodePair, distTbl distTable, sizes sizeTable) int {
+	cost := 0
+	for _, pair := range pairs {
+		cost += distTbl[pair] - c.DeleteCost*sizes.Of(pair.Left) - c.InsertCost*sizes.Of(pair.Right)
+	}
+	return cost
+}
+
+// alignSequence finds the alignment of two sequences of siblings with the
+// minimum cost of deleting, inserting and matching the nodes. Only nodes
+// with the same signature can be matched.
+func (c *Comparer) alignSequence(lefts, rights []*xtree.Node, distTbl distTable, sizes sizeTable) []nodePair {
+	n, m := len(lefts), len(rights)
+	if n == 0 || m == 0 || (n+1)*(m+1) > maxAlignCells {
+		return nil
+	}
+	del := func(i int) int { return c.DeleteCost * sizes.Of(lefts[i]) }
+	ins := func(j int) int { return c.InsertCost * sizes.Of(rights[j]) }
+	// cost[i][j] holds minimum cost of aligning first i left nodes with
+	// first j right nodes.
+	cost := make([][]int, n+1)
+	for i := range cost {
+		cost[i] = make([]int, m+1)
+	}
+	for i := 1; i <= n; i++ {
+		cost[i][0] = cost[i-1][0] + del(i-1)
+	}
+	for j := 1; j <= m; j++ {
+		cost[0][j] = cost[0][j-1] + ins(j-1)
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= m; j++ {
+			best := min(cost[i-1][j]+del(i-1), cost[i][j-1]+ins(j-1))
+			if d, ok := distTbl[nodePair{lefts[i-1], rights[j-1]}]; ok {
+				best = min(best, cost[i-1][j-1]+d)
+			}
+			cost[i][j] = best
+		}
+	}
+	var aligned []nodePair
+	for i, j := n, m; i > 0 && j > 0; {
+		pair := nodePair{lefts[i-1], rights[j-1]}
+		if d, ok := distTbl[pair]; ok && cost[i][j] == cost[i-1][j-1]+d {
+			aligned = append(aligned, pair)
+			i--
+			j--
+		} else if cost[i][j] == cost[i-1][j]+del(i-1) {
+			i--
+		} else {
+			j--
+		}
+	}
+	return aligned
+}
+
+// commonEnds pairs identical nodes at the beginning and at the end of both
+// sequences and returns the nodes in between.
+func commonEnds(lefts, rights []*xtree.Node) ([]nodePair, []*xtree.Node, []*xtree.Node) {
+	var pairs []nodePair
+	for len(lefts) > 0 && len(rights) > 0 && identical(lefts[0], rights[0]) {
+		pairs = append(pairs, nodePair{lefts[0], rights[0]})
+		lefts, rights = lefts[1:], rights[1:]
+	}
+	for len(lefts) > 0 && len(rights) > 0 && identical(lefts[len(lefts)-1], rights[len(rights)-1]) {
+		pairs = append(pairs, nodePair{lefts[len(lefts)-1], rights[len(rights)-1]})
+		lefts, rights = lefts[:len(lefts)-1], rights[:len(rights)-1]
+	}
+	return pairs, lefts, rights
+}
+
+// identical returns true if the nodes have equal subtrees.
+func identical(l, r *xtree.Node) bool {
+	return bytesEqual(l.Hash, r.Hash) && bytesEqual(l.Signature, r.Signature)
+}
+
+// reordered returns the children of the left node whose matched counterparts
+// changed their position.
+func reordered(left, right *xtree.Node, minCostM *minCostMatch) map[*xtree.Node]struct{} {
+	var pairs []nodePair
+	for l := left.FirstChild; l != nil; l = l.NextSibling {
+		if r := minCostM.Right(l); r != nil && l.Type != xtree.Attribute {
+			pairs = append(pairs, nodePair{l, r})
+		}
+	}
+	moved := make(map[*xtree.Node]struct{})
+	for _, pair := range outOfOrder(pairs, left.Children(), right.Children()) {
+		moved[pair.Left] = struct{}{}
+	}
+	return moved
+}
+
+// outOfOrder returns pairs which changed relative order of the nodes. Those
+// are the pairs outside of the longest sequence of pairs that kept the order.
+// Sibling nodes must be listed in the same order as they are in the xtree.
+func outOfOrder(pairs []nodePair, lefts, rights []*xtree.Node) []nodePair {
+	leftPos := make(map[*xtree.Node]int, len(lefts))
+	for i, n := range lefts {
+		leftPos[n] = i
+	}
+	rightPos := make(map[*xtree.Node]int, len(rights))
+	for i, n := range rights {
+		rightPos[n] = i
+	}
+	sorted := make([]nodePair, len(pairs))
+	copy(sorted, pairs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return leftPos[sorted[i].Left] < leftPos[sorted[j].Left]
+	})
+	positions := make([]int, len(sorted))
+	for i, pair := range sorted {
+		positions[i] = rightPos[pair.Right]
+	}
+	var moved []nodePair
+	kept := longestIncreasing(positions)
+	for i, pair := range sorted {
+		if len(kept) > 0 && kept[0] == i {
+			kept = kept[1:]
+			continue
+		}
+		moved = append(moved, pair)
+	}
+	return moved
+}
+
+// longestIncreasing returns indexes of the longest strictly increasing
+// subsequence of the values.
+func longestIncreasing(values []int) []int {
+	// tails[k] holds index of the smallest tail of increasing subsequences
+	// of length k+1 and prev links every value to its predecessor.
+	var tails []int
+	prev := make([]int, len(values))
+	for i, v := range values {
+		k := sort.Search(len(tails), func(k int) bool { return values[tails[k]] >= v })
+		if k > 0 {
+			prev[i] = tails[k-1]
+		} else {
+			prev[i] = -1
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+	if len(tails) == 0 {
+		return nil
+	}
+	seq := make([]int, len(tails))
+	for i, k := len(tails)-1, tails[len(tails)-1]; i >= 0; i, k = i-1, prev[k] {
+		seq[i] = k
+	}
+	return seq
+}
@@ -0,0 +1,201 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+var conformanceFailures = flag.Bool("conformance.failures", false, "log every failed conformance test case")
+
+// conformanceCatalog is the root catalog of the W3C XML conformance suite.
+const conformanceCatalog = "testfiles/xmlconf/xmlconf.xml"
+
+// conformanceCase is the TEST element of the conformance suite catalog.
+type conformanceCase struct {
+	ID       string
+	Type     string
+	Entities string
+	Sections string
+	// Path of the tested document, resolved against the xml:base of the
+	// enclosing test cases.
+	Path        string
+	Description string
+}
+
+// conformanceTypes are the types of the test cases in the order they're
+// reported.
+var conformanceTypes = []string{"valid", "invalid", "not-wf", "error"}
+
+// conformanceBaseline is the minimum number of the passed test cases per
+// parser and type. Raise it when the parser gets better, so it doesn't
+// regress.
+var conformanceBaseline = map[string]map[string]int{
+	"XDiff":    {"valid": 391, "invalid": 172, "not-wf": 284, "error": 18},
+	"Standard": {"valid": 301, "invalid": 148, "not-wf": 410, "error": 18},
+}
+
+// entityDeclaration matches the external entities of the catalog.
+var entityDeclaration = regexp.MustCompile(`<!ENTITY\s+([\w.-]+)\s+SYSTEM\s+"([^"]+)"\s*>`)
+
+// xmlDeclaration matches the declarations of the included catalogs.
+var xmlDeclaration = regexp.MustCompile(`<\?xml\s[^?]*\?>`)
+
+// loadConformanceSuite returns the test cases of the catalog. Catalog's
+// external entities are included in place since the standard decoder doesn't
+// load them.
+func loadConformanceSuite(catalog string) ([]conformanceCase, error) {
+	data, err := os.ReadFile(catalog)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(catalog)
+	end := bytes.Index(data, []byte("]>"))
+	if end < 0 {
+		return nil, fmt.Errorf("catalog %s has no internal subset", catalog)
+	}
+	body := data[end+2:]
+	for _, m := range entityDeclaration.FindAllSubmatch(data[:end], -1) {
+		part, err := os.ReadFile(filepath.Join(dir, string(m[2])))
+		if err != nil {
+			return nil, err
+		}
+		part = xmlDeclaration.ReplaceAll(part, nil)
+		body = bytes.ReplaceAll(body, []byte("&"+string(m[1])+";"), part)
+	}
+
+	var cases []conformanceCase
+	var current *conformanceCase
+	bases := []string{dir}
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return cases, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			base := bases[len(bases)-1]
+			attrs := make(map[string]string)
+			for _, a := range t.Attr {
+				if a.Name.Space == xtree.XMLNamespace && a.Name.Local == "base" {
+					base = filepath.Join(base, a.Value)
+					continue
+				}
+				attrs[a.Name.Local] = a.Value
+			}
+			bases = append(bases, base)
+			if t.Name.Local == "TEST" {
+				cases = append(cases, conformanceCase{
+					ID:       attrs["ID"],
+					Type:     attrs["TYPE"],
+					Entities: attrs["ENTITIES"],
+					Sections: attrs["SECTIONS"],
+					Path:     filepath.Join(base, attrs["URI"]),
+				})
+				current = &cases[len(cases)-1]
+			}
+		case xml.CharData:
+			if current != nil {
+				current.Description += string(t)
+			}
+		case xml.EndElement:
+			bases = bases[:len(bases)-1]
+			if t.Name.Local == "TEST" {
+				current.Description = strings.Join(strings.Fields(current.Description), " ")
+				current = nil
+			}
+		}
+	}
+}
+
+// conformanceResult returns the problem with the outcome of the test case or
+// empty string if the parser behaved as expected. Parsers are not validating
+// so the invalid documents must be accepted as well as the valid ones, while
+// the optional errors may go either way. Panic of the parser is reported
+// separately since it's a bug whatever the type of the test case.
+func conformanceResult(tc conformanceCase, parse func(string) (*xtree.Node, error)) (problem string, panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			problem, panicked = fmt.Sprintf("panic: %v", r), true
+		}
+	}()
+	_, err := parse(tc.Path)
+	switch tc.Type {
+	case "valid", "invalid":
+		if err != nil {
+			return fmt.Sprintf("rejected: %v", err), false
+		}
+	case "not-wf":
+		if err == nil {
+			return "accepted not well-formed document", false
+		}
+	}
+	return "", false
+}
+
+func TestConformance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("conformance suite is skipped in short mode")
+	}
+	cases, err := loadConformanceSuite(conformanceCatalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsers := []struct {
+		name  string
+		parse func(string) (*xtree.Node, error)
+	}{
+		{"XDiff", New().ParseFile},
+		{"Standard", NewStandard().ParseFile},
+	}
+	var card strings.Builder
+	fmt.Fprintf(&card, "%-10s", "parser")
+	for _, typ := range conformanceTypes {
+		fmt.Fprintf(&card, "%12s", typ)
+	}
+	fmt.Fprintf(&card, "%12s\n", "total")
+	for _, p := range parsers {
+		passed := make(map[string]int)
+		total := make(map[string]int)
+		for _, tc := range cases {
+			total[tc.Type]++
+			problem, panicked := conformanceResult(tc, p.parse)
+			if panicked {
+				t.Errorf("%s %s %s: %s", p.name, tc.ID, tc.Path, problem)
+				continue
+			}
+			if problem == "" {
+				passed[tc.Type]++
+				continue
+			}
+			if *conformanceFailures {
+				t.Logf("%s %s %s (%s): %s", p.name, tc.Type, tc.ID, tc.Description, problem)
+			}
+		}
+		fmt.Fprintf(&card, "%-10s", p.name)
+		var sumPassed, sumTotal int
+		for _, typ := range conformanceTypes {
+			fmt.Fprintf(&card, "%12s", fmt.Sprintf("%d/%d", passed[typ], total[typ]))
+			sumPassed += passed[typ]
+			sumTotal += total[typ]
+			if min := conformanceBaseline[p.name][typ]; passed[typ] < min {
+				t.Errorf("%s passed %d %s test cases, want at least %d", p.name, passed[typ], typ, min)
+			}
+		}
+		fmt.Fprintf(&card, "%12s\n", fmt.Sprintf("%d/%d", sumPassed, sumTotal))
+	}
+	t.Logf("W3C XML conformance of %d test cases:\n%s", len(cases), card.String())
+}
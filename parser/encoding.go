@@ -2,51 +2,183 @@ package parser
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"unicode/utf16"
 	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/transform"
 )
 
-// ensureUTF8 will check bytes for byte order marks and return proper UTF8 encoded data
-// along with starting position of the content.
-func ensureUTF8(b []byte) ([]byte, int) {
-	if bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}) {
-		// UTF8
-		return b, 3
-	} else if bytes.HasPrefix(b, []byte{0xFF, 0xFE}) {
-		// UTF 16 LE
-		var err error
-		d, err := decodeUTF16toUTF8(b)
-		if err != nil {
-			panic(err)
-		}
-		return d, 3
-	} else if bytes.HasPrefix(b, []byte{0xFE, 0xFF}) {
-		// UTF 16 BE
-		var err error
-		d, err := decodeUTF16toUTF8(b)
-		if err != nil {
-			panic(err)
+// Byte order marks of the supported encodings.
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// toUTF8 returns UTF8 encoded data along with starting position of the
+// content and the map of its offsets to the offsets in the source, which is
+// nil if the data is the source itself. Encoding is detected by the byte
+// order mark, by the UTF16 encoded "<?" at the start of the data, or else
+// taken from the encoding pseudo-attribute of the XML declaration.
+func toUTF8(b []byte) ([]byte, int, offsetMap, error) {
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		return b, len(bomUTF8), nil, nil
+	case bytes.HasPrefix(b, bomUTF16LE):
+		d, m, err := decodeUTF16(b, len(bomUTF16LE), binary.LittleEndian)
+		return d, 0, m, err
+	case bytes.HasPrefix(b, bomUTF16BE):
+		d, m, err := decodeUTF16(b, len(bomUTF16BE), binary.BigEndian)
+		return d, 0, m, err
+	case bytes.HasPrefix(b, []byte{'<', 0, '?', 0}):
+		d, m, err := decodeUTF16(b, 0, binary.LittleEndian)
+		return d, 0, m, err
+	case bytes.HasPrefix(b, []byte{0, '<', 0, '?'}):
+		d, m, err := decodeUTF16(b, 0, binary.BigEndian)
+		return d, 0, m, err
+	}
+	label := declaredEncoding(b)
+	if isUTF8(label) {
+		return b, 0, nil, nil
+	}
+	enc, err := lookupEncoding(label)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	d, m, err := decode(b, enc.NewDecoder())
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("parser: decoding %s: %v", label, err)
+	}
+	return d, 0, m, nil
+}
+
+// decode converts the data to UTF8 by the decoder one character at a time,
+// mapping the offsets of the characters to their offsets in the data. Bytes
+// which don't produce characters, like escape sequences, belong to the
+// following character.
+func decode(b []byte, dec *encoding.Decoder) ([]byte, offsetMap, error) {
+	d := make([]byte, 0, len(b)+len(b)/2)
+	var m offsetMap
+	buf := make([]byte, 64)
+	for i, start := 0, 0; i < len(b); {
+		// Source is extended byte by byte until it holds the whole
+		// character.
+		for end := i + 1; ; end++ {
+			nDst, nSrc, err := dec.Transform(buf, b[i:end], end == len(b))
+			if (err == transform.ErrShortSrc || nSrc == 0) && end < len(b) {
+				continue
+			}
+			if err == nil && nSrc == 0 {
+				err = transform.ErrShortSrc
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			i += nSrc
+			if nDst > 0 {
+				m.add(len(d), start, nDst, i-start)
+				d = append(d, buf[:nDst]...)
+				start = i
+			}
+			break
 		}
-		return d, 3
 	}
-	return b, 0
+	return d, m, nil
+}
+
+// isUTF8 reports if the data in the declared encoding is already UTF8.
+func isUTF8(label string) bool {
+	switch strings.ToLower(label) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return true
+	}
+	return false
+}
+
+// lookupEncoding returns the encoding by its IANA name or any of the labels
+// known to the browsers, such as cp1252 or latin1.
+func lookupEncoding(label string) (encoding.Encoding, error) {
+	enc, err := ianaindex.IANA.Encoding(label)
+	if err != nil || enc == nil {
+		enc, err = htmlindex.Get(label)
+	}
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("parser: unsupported encoding %q", label)
+	}
+	return enc, nil
+}
+
+// charsetReader is used by the standard decoder for the documents which were
+// already converted to UTF8 by toUTF8.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	return input, nil
 }
 
-// decodeUTF16toUTF8 handles content encoded with UTF16.
-func decodeUTF16toUTF8(b []byte) ([]byte, error) {
-	if len(b)%2 != 0 {
-		return nil, errors.New("must have even length byte slice")
-	}
-	u16s := make([]uint16, 1)
-	ret := &bytes.Buffer{}
-	b8buf := make([]byte, 4)
-	lb := len(b)
-	for i := 0; i < lb; i += 2 {
-		u16s[0] = uint16(b[i]) + (uint16(b[i+1]) << 8)
-		r := utf16.Decode(u16s)
-		n := utf8.EncodeRune(b8buf, r[0])
-		ret.Write(b8buf[:n])
-	}
-	return ret.Bytes(), nil
+// declaredEncoding returns the value of the encoding pseudo-attribute of the
+// XML declaration at the start of the data, or empty string if there's none.
+// All the supported encodings are ASCII compatible up to the end of the
+// declaration.
+func declaredEncoding(b []byte) string {
+	if !bytes.HasPrefix(b, []byte("<?xml")) || len(b) < 6 || lookupWhitespace[b[5]] != 1 {
+		return ""
+	}
+	end := bytes.Index(b, []byte("?>"))
+	if end < 0 {
+		return ""
+	}
+	decl := b[5:end]
+	i := bytes.Index(decl, []byte("encoding"))
+	if i < 0 {
+		return ""
+	}
+	decl = bytes.TrimLeft(decl[i+len("encoding"):], " \t\r\n")
+	if len(decl) == 0 || decl[0] != '=' {
+		return ""
+	}
+	decl = bytes.TrimLeft(decl[1:], " \t\r\n")
+	if len(decl) == 0 || (decl[0] != '"' && decl[0] != '\'') {
+		return ""
+	}
+	j := bytes.IndexByte(decl[1:], decl[0])
+	if j < 0 {
+		return ""
+	}
+	return string(decl[1 : j+1])
+}
+
+// decodeUTF16 converts UTF16 data in the given byte order starting at the
+// offset to UTF8, mapping the offsets of the characters to their offsets in
+// the data.
+func decodeUTF16(b []byte, start int, order binary.ByteOrder) ([]byte, offsetMap, error) {
+	if (len(b)-start)%2 != 0 {
+		return nil, nil, errors.New("parser: UTF-16 data has odd length")
+	}
+	d := make([]byte, 0, len(b)+len(b)/2)
+	var m offsetMap
+	for i := start; i < len(b); i += 2 {
+		r := rune(order.Uint16(b[i:]))
+		size := 2
+		if utf16.IsSurrogate(r) {
+			if i+4 > len(b) {
+				return nil, nil, fmt.Errorf("parser: unpaired UTF-16 surrogate at offset %d", i-start)
+			}
+			r = utf16.DecodeRune(r, rune(order.Uint16(b[i+2:])))
+			if r == utf8.RuneError {
+				return nil, nil, fmt.Errorf("parser: unpaired UTF-16 surrogate at offset %d", i-start)
+			}
+			size = 4
+		}
+		m.add(len(d), i, utf8.RuneLen(r), size)
+		d = utf8.AppendRune(d, r)
+		i += size - 2
+	}
+	return d, m, nil
 }
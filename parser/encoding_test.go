@@ -1 +1,199 @@
 package parser
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+func TestDeclaredEncoding(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`<?xml version="1.0" encoding="ISO-8859-1"?><a/>`, "ISO-8859-1"},
+		{`<?xml version='1.0' encoding = 'shift_jis' standalone='yes'?>`, "shift_jis"},
+		{"<?xml\tencoding=\"euc-jp\"?>", "euc-jp"},
+		{`<?xml version="1.0"?><a encoding="latin1"/>`, ""},
+		{`<?xml-stylesheet encoding="latin1"?>`, ""},
+		{`<a/>`, ""},
+		{`<?xml version="1.0" encoding="latin1`, ""},
+	}
+	for _, tt := range tests {
+		if got := declaredEncoding([]byte(tt.data)); got != tt.want {
+			t.Errorf("declaredEncoding(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestLegacyEncodings(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"ISO-8859-1", "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a v=\"caf\xe9\">na\xefve \xa9</a>", "naïve ©"},
+		{"ISO-8859-15", "<?xml version=\"1.0\" encoding=\"ISO-8859-15\"?><a v=\"caf\xe9\">\xa4 \xbd</a>", "€ œ"},
+		{"Windows-1252", "<?xml version=\"1.0\" encoding=\"windows-1252\"?><a v=\"caf\xe9\">\x80 \x93x\x94</a>", "€ “x”"},
+		{"Windows-1250", "<?xml version=\"1.0\" encoding=\"cp1250\"?><a v=\"caf\xe9\">\x8a\x9a</a>", "Šš"},
+		{"Shift_JIS", "<?xml version=\"1.0\" encoding=\"Shift_JIS\"?><a v=\"caf\xe9\">\x93\xfa\x96\x7b</a>", "日本"},
+		{"UTF-8", "<?xml version=\"1.0\" encoding=\"UTF-8\"?><a v=\"café\">日本</a>", "日本"},
+	}
+	parsers := []struct {
+		name  string
+		parse func([]byte) (*xtree.Node, error)
+	}{
+		{"XDiff", New().ParseBytes},
+		{"Standard", NewStandard().ParseBytes},
+	}
+	for _, p := range parsers {
+		for _, tt := range tests {
+			t.Run(p.name+" "+tt.name, func(t *testing.T) {
+				doc, err := p.parse([]byte(tt.data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				text, err := doc.Find("/a[1]/text()[1]")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(text.Value) != tt.want {
+					t.Errorf("text = %q, want %q", text.Value, tt.want)
+				}
+				if tt.name == "Shift_JIS" {
+					// 0xe9 is the lead byte of the multibyte character.
+					return
+				}
+				attr, err := doc.Find("/a[1]/@v")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(attr.Value) != "café" {
+					t.Errorf("attribute = %q, want %q", attr.Value, "café")
+				}
+			})
+		}
+	}
+}
+
+func TestUTF16(t *testing.T) {
+	// encode returns the string encoded with UTF16 in the given byte order.
+	encode := func(s string, big bool) string {
+		var b []byte
+		for _, u := range utf16.Encode([]rune(s)) {
+			if big {
+				b = append(b, byte(u>>8), byte(u))
+			} else {
+				b = append(b, byte(u), byte(u>>8))
+			}
+		}
+		return string(b)
+	}
+	doc := `<?xml version="1.0" encoding="UTF-16"?><a v="😀">日本 𝄞</a>`
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"LE BOM", "\xff\xfe" + encode(doc, false), ""},
+		{"BE BOM", "\xfe\xff" + encode(doc, true), ""},
+		{"LE without BOM", encode(doc, false), ""},
+		{"BE without BOM", encode(doc, true), ""},
+		{"Odd length", "\xff\xfe" + encode(doc, false) + "\x00", "odd length"},
+		{"Unpaired high surrogate", "\xfe\xff" + encode("<a>", true) + "\xd8\x3d" + encode("</a>", true), "unpaired UTF-16 surrogate at offset 6"},
+		{"Unpaired low surrogate", "\xff\xfe" + encode("<a>", false) + "\x00\xde", "unpaired UTF-16 surrogate at offset 6"},
+		{"Truncated surrogate", "\xff\xfe" + encode("<a>", false) + "\x3d\xd8", "unpaired UTF-16 surrogate at offset 6"},
+	}
+	parsers := []struct {
+		name  string
+		parse func([]byte) (*xtree.Node, error)
+	}{
+		{"XDiff", New().ParseBytes},
+		{"Standard", NewStandard().ParseBytes},
+	}
+	for _, p := range parsers {
+		for _, tt := range tests {
+			t.Run(p.name+" "+tt.name, func(t *testing.T) {
+				doc, err := p.parse([]byte(tt.data))
+				if tt.err != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.err) {
+						t.Fatalf("ParseBytes() error = %v, want %q", err, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				text, err := doc.Find("/a[1]/text()[1]")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(text.Value) != "日本 𝄞" {
+					t.Errorf("text = %q, want %q", text.Value, "日本 𝄞")
+				}
+				attr, err := doc.Find("/a[1]/@v")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(attr.Value) != "😀" {
+					t.Errorf("attribute = %q, want %q", attr.Value, "😀")
+				}
+			})
+		}
+	}
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="x-unknown"?><a/>`)
+	for _, parse := range []func([]byte) (*xtree.Node, error){New().ParseBytes, NewStandard().ParseBytes} {
+		_, err := parse(data)
+		if err == nil || !strings.Contains(err.Error(), `unsupported encoding "x-unknown"`) {
+			t.Errorf("ParseBytes() error = %v, want unsupported encoding", err)
+		}
+	}
+}
+
+func TestJapaneseEncodings(t *testing.T) {
+	// UTF16 version of pr-xml has different line breaks so it's compared
+	// only with its little endian version.
+	tests := []struct {
+		file string
+		same string
+	}{
+		{"pr-xml-euc-jp.xml", "pr-xml-utf-8.xml"},
+		{"pr-xml-iso-2022-jp.xml", "pr-xml-utf-8.xml"},
+		{"pr-xml-shift_jis.xml", "pr-xml-utf-8.xml"},
+		{"pr-xml-little-endian.xml", "pr-xml-utf-16.xml"},
+		{"weekly-euc-jp.xml", "weekly-utf-8.xml"},
+		{"weekly-iso-2022-jp.xml", "weekly-utf-8.xml"},
+		{"weekly-shift_jis.xml", "weekly-utf-8.xml"},
+		{"weekly-utf-16.xml", "weekly-utf-8.xml"},
+		{"weekly-little-endian.xml", "weekly-utf-8.xml"},
+	}
+	for _, tt := range tests {
+		if rootHash(t, tt.file) != rootHash(t, tt.same) {
+			t.Errorf("%s root differs from %s", tt.file, tt.same)
+		}
+	}
+}
+
+// rootHash returns the hash of the root element of the japanese conformance
+// file. Declarations and doctypes name different encodings so they're not
+// compared.
+func rootHash(t *testing.T, name string) string {
+	t.Helper()
+	doc, err := New().ParseFile(filepath.Join("testfiles/xmlconf/japanese", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == xtree.Element {
+			return string(n.Hash)
+		}
+	}
+	t.Fatalf("%s has no root element", name)
+	return ""
+}
@@ -0,0 +1,363 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// Default limits of the entity expansion.
+const (
+	DefaultMaxEntityExpansions = 10000
+	DefaultMaxEntitySize       = 1 << 20
+)
+
+// maxReferenceSize is the maximum length of the reference with the '&' and
+// without the ';'.
+const maxReferenceSize = 256
+
+// predefinedEntities are the replacements of the entities predefined by XML.
+var predefinedEntities = map[string][]byte{
+	"lt":   []byte("<"),
+	"gt":   []byte(">"),
+	"amp":  []byte("&"),
+	"apos": []byte("'"),
+	"quot": []byte("\""),
+}
+
+// entities are the general entities declared in the internal subset of the
+// DOCTYPE along with the state of their expansion.
+type entities struct {
+	values map[string][]byte
+	// external are the declared external entities, which are not read so
+	// their references stay unexpanded.
+	external map[string]bool
+	// undeclared is set if the DOCTYPE has the external subset or the
+	// parameter entity references, which may declare entities that are not
+	// read. References to the undeclared entities stay unexpanded then.
+	undeclared bool
+	// expanding are the entities being expanded, for detecting recursion.
+	expanding map[string]bool
+	// markup caches whether the entities contain markup.
+	markup map[string]bool
+	// count and size are the number of expanded references and the number
+	// of bytes they produced.
+	count, size int
+}
+
+// declareEntities declares internal general entities of the DOCTYPE's
+// internal subset. Parameter and external entities are skipped so their
+// references stay unexpanded.
+func (p *XDiff) declareEntities(doctype []byte) {
+	i := bytes.IndexByte(doctype, '[')
+	if i < 0 {
+		i = len(doctype)
+	}
+	for _, f := range bytes.Fields(doctype[:i]) {
+		if string(f) == "SYSTEM" || string(f) == "PUBLIC" {
+			p.entities.undeclared = true
+		}
+	}
+	if i == len(doctype) {
+		return
+	}
+	subset := doctype[i+1:]
+	for len(subset) > 0 {
+		switch {
+		case bytes.HasPrefix(subset, []byte("<!--")):
+			end := bytes.Index(subset, []byte("-->"))
+			if end < 0 {
+				return
+			}
+			subset = subset[end+3:]
+		case bytes.HasPrefix(subset, []byte("<!ENTITY")):
+			subset = p.declareEntity(subset[len("<!ENTITY"):])
+		case subset[0] == '"' || subset[0] == '\'':
+			end := bytes.IndexByte(subset[1:], subset[0])
+			if end < 0 {
+				return
+			}
+			subset = subset[end+2:]
+		case subset[0] == '%':
+			p.entities.undeclared = true
+			subset = subset[1:]
+		default:
+			subset = subset[1:]
+		}
+	}
+}
+
+// declareEntity declares the entity from the declaration following the
+// "<!ENTITY" and returns the rest of the subset after the declaration.
+func (p *XDiff) declareEntity(decl []byte) []byte {
+	decl = bytes.TrimLeft(decl, " \t\r\n")
+	var name, value []byte
+	literal := false
+	if len(decl) > 0 && decl[0] != '%' {
+		i := 0
+		for i < len(decl) && lookupAttributeName[decl[i]] == 1 {
+			i++
+		}
+		name = decl[:i]
+		decl = bytes.TrimLeft(decl[i:], " \t\r\n")
+		literal = len(decl) > 0 && (decl[0] == '"' || decl[0] == '\'')
+	}
+	// Skip to the end of the declaration, over the quoted literals.
+	for len(decl) > 0 && decl[0] != '>' {
+		if decl[0] != '"' && decl[0] != '\'' {
+			decl = decl[1:]
+			continue
+		}
+		end := bytes.IndexByte(decl[1:], decl[0])
+		if end < 0 {
+			return nil
+		}
+		if literal && value == nil {
+			value = expandCharRefs(decl[1 : end+1])
+		}
+		decl = decl[end+2:]
+	}
+	if len(name) == 0 {
+		return decl
+	}
+	if _, ok := predefinedEntities[string(name)]; ok {
+		return decl
+	}
+	if p.entities.values == nil {
+		p.entities.values = make(map[string][]byte)
+		p.entities.external = make(map[string]bool)
+		p.entities.markup = make(map[string]bool)
+		p.entities.expanding = make(map[string]bool)
+	}
+	// First declaration of the entity is binding.
+	if _, ok := p.entities.values[string(name)]; ok || p.entities.external[string(name)] {
+		return decl
+	}
+	if !literal || value == nil {
+		p.entities.external[string(name)] = true
+	} else {
+		p.entities.values[string(name)] = value
+	}
+	return decl
+}
+
+// expandEntity returns the replacement text of the declared entity with the
+// references in it expanded. It's used for the entities without markup,
+// entities with markup are parsed by parseEntityContent.
+func (p *XDiff) expandEntity(name string) ([]byte, error) {
+	e := &p.entities
+	if err := p.enterEntity(name); err != nil {
+		return nil, err
+	}
+	defer delete(e.expanding, name)
+	_, maxSize := p.entityLimits()
+	value := e.values[name]
+	out := make([]byte, 0, len(value))
+	// Nested expansions count their own bytes, so only the bytes of the
+	// replacement itself are added to the expanded size.
+	nested := 0
+	for i := 0; i < len(value); {
+		c := value[i]
+		if end := bytes.IndexByte(value[i:], ';'); c == '&' && end > 1 {
+			ref := string(value[i+1 : i+end])
+			if v, ok := predefinedEntities[ref]; ok {
+				out = append(out, v...)
+				i += end + 1
+				continue
+			}
+			if ref[0] == '#' {
+				r, ok := charRef([]byte(ref))
+				if !ok {
+					return nil, fmt.Errorf("invalid character reference &%s; in entity %q", ref, name)
+				}
+				out = append(out, string(r)...)
+				i += end + 1
+				continue
+			}
+			if _, ok := e.values[ref]; ok {
+				v, err := p.expandEntity(ref)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, v...)
+				nested += len(v)
+				i += end + 1
+				continue
+			}
+		}
+		out = append(out, c)
+		i++
+	}
+	if e.size += len(out) - nested; e.size > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes expanded", ErrEntityLimit, maxSize)
+	}
+	return out, nil
+}
+
+// entityLimits returns the maximum number of expanded references and the
+// maximum number of bytes they may produce.
+func (p *XDiff) entityLimits() (int, int) {
+	maxCount, maxSize := p.MaxEntityExpansions, p.MaxEntitySize
+	if maxCount == 0 {
+		maxCount = DefaultMaxEntityExpansions
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxEntitySize
+	}
+	return maxCount, maxSize
+}
+
+// enterEntity counts the expansion of the entity and marks it as being
+// expanded. It fails if the entity is already being expanded or there are
+// too many expansions.
+func (p *XDiff) enterEntity(name string) error {
+	e := &p.entities
+	if e.expanding[name] {
+		return fmt.Errorf("recursive reference to entity %q", name)
+	}
+	if maxCount, _ := p.entityLimits(); e.count+1 > maxCount {
+		return fmt.Errorf("%w: more than %d references expanded", ErrEntityLimit, maxCount)
+	}
+	e.count++
+	e.expanding[name] = true
+	return nil
+}
+
+// hasMarkup reports if the replacement text of the declared entity contains
+// markup, directly or in the entities it references.
+func (e *entities) hasMarkup(name string) bool {
+	if v, ok := e.markup[name]; ok {
+		return v
+	}
+	value := e.values[name]
+	markup := bytes.IndexByte(value, '<') >= 0
+	// Recursive references are reported once the entity is expanded.
+	e.markup[name] = markup
+	for i := 0; i < len(value) && !markup; i++ {
+		if end := bytes.IndexByte(value[i:], ';'); value[i] == '&' && end > 1 {
+			ref := string(value[i+1 : i+end])
+			if _, ok := e.values[ref]; ok {
+				markup = e.hasMarkup(ref)
+			}
+		}
+	}
+	e.markup[name] = markup
+	return markup
+}
+
+// markupReference returns the name of the declared entity with markup
+// referenced at the current position.
+func (p *XDiff) markupReference() (string, bool) {
+	end := bytes.IndexByte(p.sliceForward(maxReferenceSize), ';')
+	if p.currentByte() != '&' || end < 2 {
+		return "", false
+	}
+	name := string(p.data[p.position+1 : p.position+end])
+	if _, ok := p.entities.values[name]; !ok || !p.entities.hasMarkup(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// parseEntityContent parses the replacement text of the entity with markup
+// referenced at the current position as the content of the parent node.
+// Parsed nodes are located at the reference.
+func (p *XDiff) parseEntityContent(parent *xtree.Node, name string) error {
+	if err := p.enterEntity(name); err != nil {
+		return err
+	}
+	defer delete(p.entities.expanding, name)
+	value := p.entities.values[name]
+	if _, maxSize := p.entityLimits(); p.entities.size+len(value) > maxSize {
+		return fmt.Errorf("%w: more than %d bytes expanded", ErrEntityLimit, maxSize)
+	}
+	p.entities.size += len(value)
+	// Replacement is parsed as the content of an element without the name,
+	// closed by "</>", so it must be balanced.
+	sub := &XDiff{
+		ValidateClosingTag:  true,
+		MaxEntityExpansions: p.MaxEntityExpansions,
+		MaxEntitySize:       p.MaxEntitySize,
+		entities:            p.entities,
+	}
+	sub.data = append(append([]byte{}, value...), "</>"...)
+	sub.len = len(sub.data)
+	content := xtree.NewNode(xtree.Element)
+	err := sub.parseNodeContents(content)
+	p.entities.count, p.entities.size = sub.entities.count, sub.entities.size
+	if err != nil {
+		return err
+	}
+	if sub.position != sub.len {
+		return fmt.Errorf("unbalanced markup in entity %q", name)
+	}
+	start := p.position
+	p.position += len(name) + 2
+	for ch := content.FirstChild; ch != nil; {
+		next := ch.NextSibling
+		ch.Remove()
+		for s := []*xtree.Node{ch}; len(s) > 0; {
+			n := s[len(s)-1]
+			s = s[:len(s)-1]
+			setSpan(n, start, p.position)
+			s = append(s, n.Children()...)
+		}
+		parent.AppendChild(ch)
+		ch = next
+	}
+	return nil
+}
+
+// expandCharRefs returns the value with the character references expanded.
+func expandCharRefs(value []byte) []byte {
+	out := make([]byte, 0, len(value))
+	for i := 0; i < len(value); {
+		if end := bytes.IndexByte(value[i:], ';'); value[i] == '&' && end > 2 && value[i+1] == '#' {
+			if r, ok := charRef(value[i+1 : i+end]); ok {
+				out = append(out, string(r)...)
+				i += end + 1
+				continue
+			}
+		}
+		out = append(out, value[i])
+		i++
+	}
+	return out
+}
+
+// isName reports if the reference name is a valid XML name. Only ASCII
+// characters are checked.
+func isName(name []byte) bool {
+	if len(name) == 0 || bytes.IndexByte([]byte("-.0123456789"), name[0]) >= 0 {
+		return false
+	}
+	return !bytes.ContainsAny(name, " \t\r\n&<>\"'%#;=/")
+}
+
+// charRef returns the character of the reference name, starting with '#'.
+func charRef(name []byte) (rune, bool) {
+	base := 10
+	digits := name[1:]
+	if len(digits) > 0 && digits[0] == 'x' {
+		base = 16
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || digits[0] == '+' || digits[0] == '-' {
+		return 0, false
+	}
+	r, err := strconv.ParseUint(string(digits), base, 32)
+	if err != nil || !isChar(rune(r)) {
+		return 0, false
+	}
+	return rune(r), true
+}
+
+// isChar reports if the rune is allowed in XML documents.
+func isChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
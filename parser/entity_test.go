@@ -0,0 +1,178 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEntityExpansion(t *testing.T) {
+	doctype := `<!DOCTYPE r [
+  <!-- <!ENTITY commented "no"> -->
+  <!ENTITY company "ACME Corp">
+  <!ENTITY company "ignored">
+  <!ENTITY full "&company; &amp; Sons &#169;">
+  <!ENTITY % param "param">
+  <!ENTITY external SYSTEM "external.xml">
+  <!ENTITY quoted 'say "hi"'>
+  <!ENTITY escaped "x&#38;#38;y&#38;#x41;">
+]>`
+	tests := []struct {
+		name  string
+		body  string
+		text  string
+		value string
+	}{
+		{"Text", `<r v="x">&company;</r>`, "ACME Corp", "x"},
+		{"Attribute", `<r v="&company;, &company;">x</r>`, "x", "ACME Corp, ACME Corp"},
+		{"Nested", `<r v="&full;">&full;!</r>`, "ACME Corp & Sons ©!", "ACME Corp & Sons ©"},
+		{"Quoted", `<r v="1">&quoted;</r>`, `say "hi"`, "1"},
+		{"Double escaped", `<r v="&escaped;">&escaped;</r>`, "x&yA", "x&yA"},
+		{"External", `<r v="&external;">&external; &amp;</r>`, "&external; &", "&external;"},
+		{"Mixed", `<r v="&lt;&company;&gt;">a &#65; &company; b</r>`, "a A ACME Corp b", "<ACME Corp>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := New().ParseBytes([]byte(doctype + tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, err := doc.Find("/r[1]/text()[1]")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(text.Value) != tt.text {
+				t.Errorf("text = %q, want %q", text.Value, tt.text)
+			}
+			attr, err := doc.Find("/r[1]/@v")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(attr.Value) != tt.value {
+				t.Errorf("attribute = %q, want %q", attr.Value, tt.value)
+			}
+		})
+	}
+}
+
+func TestEntityMarkup(t *testing.T) {
+	doctype := `<!DOCTYPE r [
+  <!ENTITY company "ACME Corp">
+  <!ENTITY bold "<b>&company;</b>">
+  <!ENTITY title "&bold; &#38;lt;Ltd&#38;gt;">
+  <!ENTITY open "<b>">
+  <!ENTITY close "</b>">
+]>`
+	tests := []struct {
+		name string
+		body string
+		want map[string]string
+	}{
+		{"Element", `<r>x&bold;y</r>`, map[string]string{
+			"/r[1]/text()[1]":      "x",
+			"/r[1]/b[1]/text()[1]": "ACME Corp",
+			"/r[1]/text()[2]":      "y",
+		}},
+		{"Nested", `<r>&title;</r>`, map[string]string{
+			"/r[1]/b[1]/text()[1]": "ACME Corp",
+			"/r[1]/text()[1]":      "<Ltd>",
+		}},
+		{"Unbalanced start", `<r>&open;</r>`, nil},
+		{"Unbalanced end", `<r><b>&close;</r>`, nil},
+		{"Attribute", `<r v="&bold;"/>`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := New().ParseBytes([]byte(doctype + tt.body))
+			if tt.want == nil {
+				var se *SyntaxError
+				if !errors.As(err, &se) {
+					t.Errorf("ParseBytes() error = %v, want SyntaxError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for path, want := range tt.want {
+				n, err := doc.Find(path)
+				if err != nil {
+					t.Fatalf("Find(%s) error %v", path, err)
+				}
+				if string(n.Value) != want {
+					t.Errorf("%s = %q, want %q", path, n.Value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUndeclaredEntity(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		fails bool
+	}{
+		{"Without DOCTYPE", `<r>&a;</r>`, true},
+		{"Commented out", `<!DOCTYPE r [<!-- <!ENTITY a "a"> -->]><r>&a;</r>`, true},
+		{"Parameter entity", `<!DOCTYPE r [<!ENTITY % a "a">]><r>&a;</r>`, true},
+		{"External subset", `<!DOCTYPE r SYSTEM "r.dtd"><r>&a;</r>`, false},
+		{"Parameter entity reference", `<!DOCTYPE r [<!ENTITY % d SYSTEM "d.ent"> %d;]><r>&a;</r>`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := New().ParseBytes([]byte(tt.data))
+			if tt.fails {
+				var se *SyntaxError
+				if !errors.As(err, &se) {
+					t.Errorf("ParseBytes() error = %v, want SyntaxError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text, _ := doc.Find("/r[1]/text()[1]"); text == nil || string(text.Value) != "&a;" {
+				t.Errorf("text = %v, want &a;", text)
+			}
+		})
+	}
+}
+
+func TestEntityLimits(t *testing.T) {
+	laughs := `<!DOCTYPE r [<!ENTITY lol0 "lol">`
+	for i := 1; i < 10; i++ {
+		lol := strings.Repeat("&lol"+string(rune('0'+i-1))+";", 10)
+		laughs += `<!ENTITY lol` + string(rune('0'+i)) + ` "` + lol + `">`
+	}
+	laughs += `]><r>&lol9;</r>`
+	tests := []struct {
+		name          string
+		data          string
+		maxExpansions int
+		maxSize       int
+		fails         bool
+		limit         bool
+	}{
+		{"Billion laughs", laughs, 0, 0, true, true},
+		{"Expansions", `<!DOCTYPE r [<!ENTITY a "a">]><r>&a;&a;&a;</r>`, 2, 0, true, true},
+		{"Size", `<!DOCTYPE r [<!ENTITY a "aaaa">]><r v="&a;&a;">x</r>`, 0, 7, true, true},
+		{"Within limits", `<!DOCTYPE r [<!ENTITY a "aaaa">]><r v="&a;&a;">&a;</r>`, 3, 12, false, false},
+		{"Nested size", `<!DOCTYPE r [<!ENTITY a "aaaa"><!ENTITY b "&a;&a;">]><r>&b;</r>`, 0, 8, false, false},
+		{"Markup size", `<!DOCTYPE r [<!ENTITY a "<a/>"><!ENTITY b "&a;&a;">]><r>&b;</r>`, 0, 7, true, true},
+		{"Markup expansions", `<!DOCTYPE r [<!ENTITY a "<a/>"><!ENTITY b "&a;&a;">]><r>&b;</r>`, 2, 0, true, true},
+		{"Markup recursion", `<!DOCTYPE r [<!ENTITY a "<a>&b;</a>"><!ENTITY b "&a;">]><r>&a;</r>`, 0, 0, true, false},
+		{"Recursion", `<!DOCTYPE r [<!ENTITY a "&b;"><!ENTITY b "&a;">]><r>&a;</r>`, 0, 0, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New()
+			p.MaxEntityExpansions = tt.maxExpansions
+			p.MaxEntitySize = tt.maxSize
+			_, err := p.ParseBytes([]byte(tt.data))
+			if tt.fails != (err != nil) || tt.limit != errors.Is(err, ErrEntityLimit) {
+				t.Errorf("ParseBytes() error = %v, want error %v, limit error %v", err, tt.fails, tt.limit)
+			}
+		})
+	}
+}
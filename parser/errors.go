@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// ErrUnexpectedEnd represents attempted read beyond available data.
+var ErrUnexpectedEnd = errors.New("parser: unexpected end of data")
+
+// ErrEntityLimit represents expansion of the entities beyond the parser's
+// limits.
+var ErrEntityLimit = errors.New("parser: entity expansion limit exceeded")
+
+// SyntaxError describes malformed XML and its location in the parsed
+// document.
+type SyntaxError struct {
+	// File is the path of the parsed file, it's empty for the parsed
+	// bytes and readers.
+	File string
+	// Pos is the position of the error.
+	Pos xtree.Position
+	// Expected and Found are the expected and the found token if the
+	// error is caused by the unexpected token, Found is EOF at the end of
+	// the document.
+	Expected, Found string
+	// Msg is the description of the error.
+	Msg string
+	// Context is the snippet of the document around the error with the
+	// byte at the position in curly braces.
+	Context string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *SyntaxError) Error() string {
+	loc := e.Pos.String()
+	if e.File != "" {
+		loc = e.File + ":" + loc
+	}
+	if e.Context == "" {
+		return fmt.Sprintf("parser: %s: %s", loc, e.Msg)
+	}
+	return fmt.Sprintf("parser: %s: %s\n%s", loc, e.Msg, e.Context)
+}
+
+// Unwrap returns the underlying error.
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// newSyntaxError returns syntax error caused by err at the offset of the
+// document.
+func newSyntaxError(err error, data []byte, lines lineIndex, offset int) *SyntaxError {
+	var se *SyntaxError
+	if !errors.As(err, &se) {
+		se = &SyntaxError{
+			Msg: strings.TrimPrefix(err.Error(), "parser: "),
+			Err: err,
+		}
+	}
+	if offset > len(data) {
+		offset = len(data)
+	}
+	se.Pos = lines.position(offset)
+	if se.Found == "" && errors.Is(err, ErrUnexpectedEnd) {
+		se.Found = "EOF"
+	}
+	if se.Found == "" && se.Expected != "" {
+		se.Found = "EOF"
+		if offset < len(data) {
+			se.Found = fmt.Sprintf("%q", rune(data[offset]))
+		}
+	}
+	if se.Msg == "" {
+		se.Msg = fmt.Sprintf("expected %s but found %s", se.Expected, se.Found)
+	}
+	se.Context = errorContext(data, offset)
+	return se
+}
+
+// errorContext returns the snippet of the data around the offset.
+func errorContext(data []byte, offset int) string {
+	const contextSize = 40
+	left := string(data[max(offset-contextSize, 0):offset])
+	if offset >= len(data) {
+		return left
+	}
+	right := string(data[min(offset+1, len(data)):min(offset+contextSize, len(data))])
+	return fmt.Sprintf("%s{%c}%s", left, data[offset], right)
+}
+
+// withFile sets the file of the syntax error.
+func withFile(err error, file string) error {
+	var se *SyntaxError
+	if errors.As(err, &se) && se.File == "" {
+		se.File = file
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		name     string
+		parse    func([]byte) (*xtree.Node, error)
+		data     string
+		pos      string
+		expected string
+		found    string
+	}{
+		{"XDiff closing tag", New().ParseBytes, "<root>\n  <a></b>\n</root>", "2:9", "</a>", "</b>"},
+		{"XDiff attribute", New().ParseBytes, "<root>\n<a id></a></root>", "2:6", "'='", "'>'"},
+		{"XDiff text", New().ParseBytes, "<root/>\ntext", "2:1", "'<'", "'t'"},
+		{"XDiff end", New().ParseBytes, "<root><!-- a", "1:12", "", "EOF"},
+		{"XDiff invalid character", New().ParseBytes, "<root>\n<a>\x00</a></root>", "2:4", "character data", `'\x00'`},
+		{"Standard closing tag", NewStandard().ParseBytes, "<root>\n  <a></b>\n</root>", "2:10", "", ""},
+		{"Standard end", NewStandard().ParseBytes, "<root>\n<a>", "2:4", "", "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.parse([]byte(tt.data))
+			var se *SyntaxError
+			if !errors.As(err, &se) {
+				t.Fatalf("parse() error = %v, want SyntaxError", err)
+			}
+			if se.Pos.String() != tt.pos {
+				t.Errorf("SyntaxError at %s, want %s", se.Pos, tt.pos)
+			}
+			if se.Expected != tt.expected || se.Found != tt.found {
+				t.Errorf("SyntaxError expected %q found %q, want expected %q found %q", se.Expected, se.Found, tt.expected, tt.found)
+			}
+			if se.Msg == "" || se.Context == "" {
+				t.Errorf("SyntaxError without message or context: %#v", se)
+			}
+		})
+	}
+}
+
+func TestSyntaxErrorFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "sub", "broken.xml")
+	if err := os.WriteFile(file, []byte("<root>\n<a></root>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parsers := map[string]interface {
+		ParseFile(string) (*xtree.Node, error)
+		ParseDir(string) (*xtree.Node, error)
+	}{
+		"XDiff":    New(),
+		"Standard": NewStandard(),
+	}
+	for name, p := range parsers {
+		t.Run(name, func(t *testing.T) {
+			_, err := p.ParseFile(file)
+			var se *SyntaxError
+			if !errors.As(err, &se) || se.File != file {
+				t.Errorf("ParseFile() error = %v, want SyntaxError in %s", err, file)
+			}
+			_, err = p.ParseDir(dir)
+			if !errors.As(err, &se) || se.File != file || se.Pos.Line != 2 {
+				t.Errorf("ParseDir() error = %v, want SyntaxError in %s:2", err, file)
+			}
+		})
+	}
+}
+
+func TestParseDirEncodingError(t *testing.T) {
+	valid, invalid := t.TempDir(), t.TempDir()
+	// UTF-16 surrogate pair straddles the first bytes read from the file.
+	data := []byte{0xFF, 0xFE, 0x3D, 0xD8, 0x00, 0xDE}
+	if err := os.WriteFile(filepath.Join(valid, "emoji.txt"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(invalid, "odd.xml")
+	if err := os.WriteFile(file, []byte{0xFF, 0xFE, '<'}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	parsers := map[string]interface {
+		ParseDir(string) (*xtree.Node, error)
+	}{
+		"XDiff":    New(),
+		"Standard": NewStandard(),
+	}
+	for name, p := range parsers {
+		t.Run(name, func(t *testing.T) {
+			if _, err := p.ParseDir(valid); err != nil {
+				t.Errorf("ParseDir() error = %v, want nil", err)
+			}
+			_, err := p.ParseDir(invalid)
+			if err == nil || !strings.Contains(err.Error(), file) {
+				t.Errorf("ParseDir() error = %v, want decoding error of %s", err, file)
+			}
+		})
+	}
+}
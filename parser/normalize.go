@@ -0,0 +1,251 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ajankovic/xdiff/xtree"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization is a set of transformations of the text and attribute values
+// applied before the xtree is prepared, so the documents which differ only
+// in formatting produce the same xtrees.
+type Normalization uint
+
+const (
+	// NormalizeNewlines replaces "\r\n" and "\r" line endings with "\n".
+	NormalizeNewlines Normalization = 1 << iota
+	// NFC converts the values to Unicode Normalization Form C.
+	NFC
+	// CollapseSpace replaces each run of whitespace with a single space.
+	CollapseSpace
+	// TrimSpace removes leading and trailing whitespace.
+	TrimSpace
+	// DropBlank removes the text nodes containing only whitespace.
+	DropBlank
+)
+
+// normalizationNames are the names of the normalizations in the order they
+// are applied.
+var normalizationNames = []struct {
+	n    Normalization
+	name string
+}{
+	{NormalizeNewlines, "newlines"},
+	{NFC, "nfc"},
+	{CollapseSpace, "collapse"},
+	{TrimSpace, "trim"},
+	{DropBlank, "drop-blank"},
+}
+
+// ParseNormalization returns the normalization made of the named ones,
+// newlines, nfc, collapse, trim, drop-blank, or none for no normalization.
+func ParseNormalization(names ...string) (Normalization, error) {
+	var n Normalization
+	for _, name := range names {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "none" {
+			continue
+		}
+		found := false
+		for _, nn := range normalizationNames {
+			if nn.name == name {
+				n |= nn.n
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("parser: unknown normalization %q", name)
+		}
+	}
+	return n, nil
+}
+
+// String returns comma separated names of the normalizations.
+func (n Normalization) String() string {
+	var names []string
+	for _, nn := range normalizationNames {
+		if n&nn.n != 0 {
+			names = append(names, nn.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Apply returns the normalized value. Value is returned as is if it doesn't
+// change.
+func (n Normalization) Apply(v []byte) []byte {
+	if n&NormalizeNewlines != 0 && bytes.IndexByte(v, '\r') >= 0 {
+		v = bytes.ReplaceAll(v, []byte("\r\n"), []byte("\n"))
+		v = bytes.ReplaceAll(v, []byte("\r"), []byte("\n"))
+	}
+	if n&NFC != 0 && !norm.NFC.IsNormal(v) {
+		v = norm.NFC.Bytes(v)
+	}
+	if n&CollapseSpace != 0 {
+		v = collapseSpace(v)
+	}
+	if n&TrimSpace != 0 {
+		v = bytes.Trim(v, xmlWhitespace)
+	}
+	return v
+}
+
+// xmlWhitespace are the whitespace characters of the XML documents.
+const xmlWhitespace = " \t\r\n"
+
+// collapseSpace replaces each run of whitespace with a single space.
+func collapseSpace(v []byte) []byte {
+	var out []byte
+	for i := 0; i < len(v); i++ {
+		if lookupWhitespace[v[i]] != 1 {
+			if out != nil {
+				out = append(out, v[i])
+			}
+			continue
+		}
+		j := i + 1
+		for j < len(v) && lookupWhitespace[v[j]] == 1 {
+			j++
+		}
+		if out == nil {
+			if j-i == 1 && v[i] == ' ' {
+				// Single space stays as is.
+				i = j - 1
+				continue
+			}
+			out = append(make([]byte, 0, len(v)), v[:i]...)
+		}
+		out = append(out, ' ')
+		i = j - 1
+	}
+	if out == nil {
+		return v
+	}
+	return out
+}
+
+// Normalizer normalizes the text and attribute values of the xtree by their
+// signatures. Signatures are set as paths of node names, for example
+// "/project/description" for the text of the description elements and
+// "/project/id" for the id attribute of the project, or as complete node
+// signatures with type suffix like "/project/description/Data". Names of the
+// namespaced nodes may be given as local names, with their prefixes or as
+// {uri}local.
+type Normalizer struct {
+	// Default is used for nodes with signatures not set in the normalizer.
+	Default    Normalization
+	signatures map[string]Normalization
+}
+
+// NewNormalizer creates normalizer applying the normalization to all the
+// nodes.
+func NewNormalizer(n Normalization) *Normalizer {
+	return &Normalizer{Default: n, signatures: make(map[string]Normalization)}
+}
+
+// Set sets normalization of the nodes with the signature.
+func (nz *Normalizer) Set(signature string, n Normalization) {
+	if nz.signatures == nil {
+		nz.signatures = make(map[string]Normalization)
+	}
+	nz.signatures[signature] = n
+}
+
+// Normalization returns the normalization of the node.
+func (nz *Normalizer) Normalization(n *xtree.Node) Normalization {
+	if len(nz.signatures) == 0 {
+		return nz.Default
+	}
+	n.CalculateSignature()
+	suffix := "/" + n.Type.String()
+	return nz.lookup(namePaths{
+		strings.TrimSuffix(string(n.Signature), suffix),
+		strings.TrimSuffix(string(n.QualifiedSignature()), suffix),
+		strings.TrimSuffix(string(n.LocalSignature()), suffix),
+	}, n.Type)
+}
+
+// namePaths are the paths of the node made of the expanded, prefixed and
+// local names.
+type namePaths [3]string
+
+// child returns the paths of the child node.
+func (p namePaths) child(n *xtree.Node) namePaths {
+	return namePaths{
+		p[0] + "/" + string(n.ExpandedName()),
+		p[1] + "/" + string(n.Name),
+		p[2] + "/" + string(n.LocalName()),
+	}
+}
+
+// lookup returns the normalization of the nodes of the type at the paths.
+// Paths are tried in order, each with the type suffix first.
+func (nz *Normalizer) lookup(paths namePaths, t xtree.NodeType) Normalization {
+	if len(nz.signatures) == 0 {
+		return nz.Default
+	}
+	for i, path := range paths {
+		if i > 0 && (path == paths[0] || path == paths[i-1]) {
+			continue
+		}
+		if v, ok := nz.signatures[path+"/"+t.String()]; ok {
+			return v
+		}
+		if v, ok := nz.signatures[path]; ok {
+			return v
+		}
+	}
+	return nz.Default
+}
+
+// Normalize normalizes the values of the data and attribute nodes of the
+// xtree. Signatures of the documents' nodes are relative to the documents.
+// Signatures and hashes of the nodes are not updated so the xtree has to be
+// prepared afterwards.
+func (nz *Normalizer) Normalize(root *xtree.Node) {
+	type frame struct {
+		n     *xtree.Node
+		paths namePaths
+	}
+	for s := []frame{{n: root}}; len(s) > 0; {
+		f := s[len(s)-1]
+		s = s[:len(s)-1]
+		switch f.n.Type {
+		case xtree.Element:
+			f.paths = f.paths.child(f.n)
+		case xtree.Document:
+			f.paths = namePaths{}
+		}
+		for ch := f.n.FirstChild; ch != nil; {
+			next := ch.NextSibling
+			switch ch.Type {
+			case xtree.Data:
+				normalization := nz.lookup(f.paths, ch.Type)
+				if normalization&DropBlank != 0 && len(bytes.Trim(ch.Value, xmlWhitespace)) == 0 {
+					ch.Remove()
+				} else {
+					ch.Value = normalization.Apply(ch.Value)
+				}
+			case xtree.Attribute:
+				ch.Value = nz.lookup(f.paths.child(ch), ch.Type).Apply(ch.Value)
+			default:
+				s = append(s, frame{ch, f.paths})
+			}
+			ch = next
+		}
+	}
+}
+
+// Prepare resolves the namespaces of the xtree parsed by any parser, then
+// normalizes its values and sets signatures and hashes of its nodes.
+func (nz *Normalizer) Prepare(root *xtree.Node) error {
+	xtree.ResolveNamespaces(root)
+	nz.Normalize(root)
+	return xtree.Prepare(root)
+}
@@ -0,0 +1,207 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+func TestNormalizationApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     Normalization
+		value string
+		want  string
+	}{
+		{"None", 0, " a\r\n  b ", " a\r\n  b "},
+		{"Newlines", NormalizeNewlines, "a\r\nb\rc\n", "a\nb\nc\n"},
+		{"NFC", NFC, "café", "café"},
+		{"Collapse", CollapseSpace, " a \t\n b  c ", " a b c "},
+		{"Collapse single spaces", CollapseSpace, "a b c", "a b c"},
+		{"Trim", TrimSpace, "\n\t a  b \r\n", "a  b"},
+		{"Trim and collapse", TrimSpace | CollapseSpace, "\n  a\n  b\n", "a b"},
+		{"All", NormalizeNewlines | NFC | CollapseSpace | TrimSpace, " é\r\n\r\n x ", "é x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(tt.n.Apply([]byte(tt.value))); got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNormalization(t *testing.T) {
+	n, err := ParseNormalization("trim", "Collapse", "drop-blank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != TrimSpace|CollapseSpace|DropBlank {
+		t.Errorf("ParseNormalization() = %s, want collapse,trim,drop-blank", n)
+	}
+	if n.String() != "collapse,trim,drop-blank" {
+		t.Errorf("String() = %s, want collapse,trim,drop-blank", n)
+	}
+	if n, err := ParseNormalization("none"); err != nil || n != 0 || n.String() != "none" {
+		t.Errorf("ParseNormalization(none) = %s, %v, want none", n, err)
+	}
+	if _, err := ParseNormalization("trim", "upper"); err == nil {
+		t.Errorf("ParseNormalization(upper) expected error")
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	compact := "<doc id=\"1\"><p>Some text here</p><pre>keep  \n  this </pre></doc>"
+	formatted := "<doc id=\" 1 \">\r\n  <p>\r\n    Some  text\r\n    here\r\n  </p>\r\n  <pre>keep  \r\n  this </pre>\r\n</doc>"
+	parsers := []struct {
+		name  string
+		parse func(*Normalizer, []byte) (*xtree.Node, error)
+	}{
+		{"XDiff", func(nz *Normalizer, b []byte) (*xtree.Node, error) {
+			p := New()
+			p.Normalizer = nz
+			return p.ParseBytes(b)
+		}},
+		{"Standard", func(nz *Normalizer, b []byte) (*xtree.Node, error) {
+			p := NewStandard()
+			p.Normalizer = nz
+			return p.ParseBytes(b)
+		}},
+	}
+	var hashes []string
+	for _, p := range parsers {
+		t.Run(p.name, func(t *testing.T) {
+			nz := NewNormalizer(NormalizeNewlines | CollapseSpace | TrimSpace | DropBlank)
+			nz.Set("/doc/pre", NormalizeNewlines)
+			left, err := p.parse(nz, []byte(compact))
+			if err != nil {
+				t.Fatal(err)
+			}
+			right, err := p.parse(nz, []byte(formatted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(left.Hash) != string(right.Hash) {
+				lt, _ := xtree.TextString(left)
+				rt, _ := xtree.TextString(right)
+				t.Errorf("normalized tree\n%s\ndiffers from\n%s", lt, rt)
+			}
+			pre, err := right.Find("/doc[1]/pre[1]/text()[1]")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(pre.Value) != "keep  \n  this " {
+				t.Errorf("pre = %q, want %q", pre.Value, "keep  \n  this ")
+			}
+			hashes = append(hashes, string(right.Hash))
+
+			raw, err := p.parse(nil, []byte(formatted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raw.Hash) == string(right.Hash) {
+				t.Errorf("tree parsed without normalizer is normalized")
+			}
+		})
+	}
+	if len(hashes) == 2 && hashes[0] != hashes[1] {
+		t.Errorf("normalized trees of the parsers differ")
+	}
+}
+
+func TestNormalizerPrepare(t *testing.T) {
+	formatted := "<doc id=\" 1 \">\r\n  <p>\r\n    Some  text\r\n    here\r\n  </p>\r\n  <pre>keep  \r\n  this </pre>\r\n</doc>"
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "doc.xml"), []byte(formatted), 0644); err != nil {
+		t.Fatal(err)
+	}
+	newNormalizer := func() *Normalizer {
+		nz := NewNormalizer(NormalizeNewlines | CollapseSpace | TrimSpace | DropBlank)
+		nz.Set("/doc/pre", NormalizeNewlines)
+		return nz
+	}
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			p, err := Lookup(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := p.ParseBytes([]byte(formatted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := newNormalizer().Prepare(doc); err != nil {
+				t.Fatal(err)
+			}
+			// Built-in parsers normalize the documents while parsing.
+			p = New()
+			p.(*XDiff).Normalizer = newNormalizer()
+			want, err := p.ParseBytes([]byte(formatted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(doc.Hash) != string(want.Hash) {
+				got, _ := xtree.TextString(doc)
+				wt, _ := xtree.TextString(want)
+				t.Errorf("prepared tree\n%s\ndiffers from\n%s", got, wt)
+			}
+
+			p, _ = Lookup(name)
+			root, err := p.ParseDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := newNormalizer().Prepare(root); err != nil {
+				t.Fatal(err)
+			}
+			pre, err := root.Find("/document(doc.xml)[1]/doc[1]/pre[1]/text()[1]")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(pre.Value) != "keep  \n  this " {
+				t.Errorf("pre = %q, want %q", pre.Value, "keep  \n  this ")
+			}
+		})
+	}
+}
+
+func TestNormalizerNamespaces(t *testing.T) {
+	const uri = "http://maven.apache.org/POM/4.0.0"
+	tests := []struct {
+		name string
+		doc  string
+		path string
+	}{
+		{"Local names", `<project xmlns="` + uri + `"><pre>keep  this</pre><p>collapse  this</p></project>`, "/project/pre"},
+		{"Local names of prefixed nodes", `<m:project xmlns:m="` + uri + `"><m:pre>keep  this</m:pre><m:p>collapse  this</m:p></m:project>`, "/project/pre/Data"},
+		{"Prefixed names", `<m:project xmlns:m="` + uri + `"><m:pre>keep  this</m:pre><m:p>collapse  this</m:p></m:project>`, "/m:project/m:pre"},
+		{"Expanded names", `<project xmlns="` + uri + `"><pre>keep  this</pre><p>collapse  this</p></project>`, "/{" + uri + "}project/{" + uri + "}pre"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nz := NewNormalizer(CollapseSpace)
+			nz.Set(tt.path, 0)
+			p := New()
+			p.Normalizer = nz
+			doc, err := p.ParseBytes([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			root := doc.FirstChild
+			for ch := root.FirstChild; ch != nil; ch = ch.NextSibling {
+				if ch.Type != xtree.Element {
+					continue
+				}
+				want := "keep  this"
+				if string(ch.LocalName()) == "p" {
+					want = "collapse this"
+				}
+				if got := string(ch.FirstChild.Value); got != want {
+					t.Errorf("%s = %q, want %q", ch.Name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -23,6 +23,8 @@
 // Example API usage:
 //
 //   p := parser.New()
+//   // or look up the parser by its name, "fast" or "standard"
+//   p, err := parser.Lookup("standard")
 //   // to parse from reader
 //	 xtree, err := p.ParseReader(openedFileReader)
 //   // to parse from bytes
@@ -36,13 +38,13 @@ package parser
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"unicode/utf8"
 
 	"github.com/ajankovic/xdiff/xtree"
 )
@@ -63,10 +65,23 @@ type XDiff struct {
 	NonXMLHandler func(f *os.File, fi os.FileInfo) (*xtree.Node, error)
 	// Set document node name to filename when parsing xml by filename.
 	SetDocumentFilename bool
+	// Maximum number of expanded references to the entities declared in
+	// the DOCTYPE, including the references in their values. Zero value
+	// means DefaultMaxEntityExpansions.
+	MaxEntityExpansions int
+	// Maximum number of bytes produced by expanding the entities declared
+	// in the DOCTYPE. Zero value means DefaultMaxEntitySize.
+	MaxEntitySize int
+	// Normalizer of the text and attribute values applied before the parsed
+	// xtree is prepared. Values are kept as parsed if it's nil.
+	Normalizer *Normalizer
 
 	position int
 	len      int
 	data     []byte
+	lines    lineIndex
+	runeBuf  [utf8.UTFMax]byte
+	entities entities
 }
 
 // New instantiates new parser.
@@ -91,7 +106,8 @@ func (p *XDiff) ParseFile(filepath string) (*xtree.Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	return p.ParseBytes(b)
+	n, err := p.ParseBytes(b)
+	return n, withFile(err, filepath)
 }
 
 // ParseDir returns reference to the directory node got by parsing provided dirpath.
@@ -149,24 +165,40 @@ func (p *XDiff) parseDirFile(filepath string, fi os.FileInfo) (*xtree.Node, erro
 	defer f.Close()
 
 	b := make([]byte, 4)
-	_, err = f.Read(b)
+	read, err := f.Read(b)
 	if err == io.EOF {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	b, i := ensureUTF8(b)
+	size := fi.Size() + bytes.MinRead
+	b, i, _, err := toUTF8(b[:read])
+	if err != nil {
+		// Decoding fails if the first bytes end inside of a character, so
+		// the error is returned only if the whole file can't be decoded.
+		if _, err = f.Seek(0, 0); err != nil {
+			return nil, err
+		}
+		if b, err = readAll(f, size); err != nil {
+			return nil, err
+		}
+		if b, i, _, err = toUTF8(b); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath, err)
+		}
+	}
 	// Reset reader.
 	_, err = f.Seek(0, 0)
 	if err != nil {
 		return nil, err
 	}
-	size := fi.Size() + bytes.MinRead
 	var n *xtree.Node
-	if b[i] == '<' {
-		b, err = readAll(f, size)
+	if i < len(b) && b[i] == '<' {
+		if b, err = readAll(f, size); err != nil {
+			return nil, err
+		}
 		n, err = p.ParseBytes(b)
+		err = withFile(err, filepath)
 	} else if p.NonXMLHandler != nil {
 		n, err = p.NonXMLHandler(f, fi)
 	}
@@ -205,28 +237,45 @@ func readAll(r io.Reader, capacity int64) (b []byte, err error) {
 // provided slice. Do not manipulate with it until you are done using the parsed structure.
 // Pass the copy of the slice if you want to maintain ownership of the bytes.
 func (p *XDiff) ParseBytes(b []byte) (*xtree.Node, error) {
-	p.data, p.position = ensureUTF8(b)
+	var err error
+	var offsets offsetMap
+	p.data, p.position, offsets, err = toUTF8(b)
+	if err != nil {
+		return nil, err
+	}
 	p.len = len(p.data)
+	p.entities = entities{}
+	// Lines are indexed before parsing since expanding of the references
+	// overwrites the data.
+	p.lines = newLineIndex(p.data, offsets)
 	doc := xtree.NewDocument(nil)
+	setSpan(doc, 0, p.len)
 
-	for p.position < p.len {
+	for {
 		p.skip(lookupWhitespace)
-		if p.position == p.len-1 {
+		if p.position >= p.len {
 			// Clean exit.
 			break
 		}
 		if p.currentByte() == '<' {
+			start := p.position
 			p.position++
 			parsed, err := p.parseNode()
 			if err != nil {
 				return doc, p.wrapError(err)
 			}
+			setSpan(parsed, start, p.position)
 			doc.AppendChild(parsed)
 		} else {
-			return doc, p.wrapError(fmt.Errorf("expected '<', but found %q", rune(p.data[p.position])))
+			return doc, p.wrapError(p.expected("'<'"))
 		}
 	}
 
+	p.lines.setPositions(doc)
+	xtree.ResolveNamespaces(doc)
+	if p.Normalizer != nil {
+		p.Normalizer.Normalize(doc)
+	}
 	if err := xtree.Prepare(doc); err != nil {
 		return doc, err
 	}
@@ -236,8 +285,10 @@ func (p *XDiff) ParseBytes(b []byte) (*xtree.Node, error) {
 
 // parseNode is the highest level parsing method; expects position to be after a '<'.
 func (p *XDiff) parseNode() (*xtree.Node, error) {
-	c := p.data[p.position]
-	switch c {
+	if p.position >= p.len {
+		return nil, ErrUnexpectedEnd
+	}
+	switch p.currentByte() {
 	case '?':
 		if err := p.skipBytes(4); err != nil {
 			return nil, ErrUnexpectedEnd
@@ -272,7 +323,7 @@ func (p *XDiff) parseNode() (*xtree.Node, error) {
 				}
 				return n, nil
 			}
-
+			return nil, p.expected("'<!--'")
 		// <![
 		case '[':
 			err := p.skipBytes(1)
@@ -283,7 +334,7 @@ func (p *XDiff) parseNode() (*xtree.Node, error) {
 			if !bytes.HasPrefix(p.sliceToEnd(), []byte("CDATA[")) {
 				return nil, fmt.Errorf("unexpected data following <![")
 			}
-			p.skipBytes(6) // skip <![CDATA[
+			p.position += 6 // skip <![CDATA[
 			n, err := p.parseCDATA()
 			if err != nil {
 				return nil, err
@@ -295,7 +346,7 @@ func (p *XDiff) parseNode() (*xtree.Node, error) {
 			if err != nil {
 				return nil, err
 			}
-			if bytes.HasPrefix(p.sliceToEnd(), []byte("OCTYPE")) && lookupWhitespace[p.data[p.position+6]] == 1 {
+			if d := p.sliceForward(7); bytes.HasPrefix(d, []byte("OCTYPE")) && len(d) == 7 && lookupWhitespace[d[6]] == 1 {
 				// "<!DOCTYPE "
 				p.skipBytes(6)
 				n, err := p.parseDocType()
@@ -317,14 +368,13 @@ func (p *XDiff) parseNode() (*xtree.Node, error) {
 	default:
 		return p.parseElement()
 	}
-
-	return nil, p.skipToChar('>')
 }
 
 // parseAttributes parses the attribute of an element, returns an AttributeNode.
 func (p *XDiff) parseAttributes(element *xtree.Node) error {
 	for lookupAttributeName[p.currentByte()] == 1 {
 		start := p.position
+		attrStart := start
 		p.position++
 		p.skip(lookupAttributeName)
 		attrNode := xtree.NewNode(xtree.Attribute)
@@ -334,7 +384,7 @@ func (p *XDiff) parseAttributes(element *xtree.Node) error {
 		p.skip(lookupWhitespace)
 		// skip "="
 		if p.currentByte() != '=' {
-			return fmt.Errorf("expected '=' but found %q at position %v", p.data[p.position], p.position)
+			return p.expected("'='")
 		}
 		p.position++
 
@@ -342,30 +392,32 @@ func (p *XDiff) parseAttributes(element *xtree.Node) error {
 		p.skip(lookupWhitespace)
 		q := p.currentByte()
 		if q != '\'' && q != '"' {
-			return fmt.Errorf("expected ' or \" but found %q at position %v", q, p.position)
+			return p.expected(`' or "`)
 		}
 		p.position++ // Skip quote
 		// Extract attribute value, and expand char refs in it
 		start = p.position
 		var value []byte
+		var err error
 		if q == '\'' {
-			value = p.skipAndExpandCharacterRefs(lookupAttributeValueSingle, lookupAttributeValueSingleNoProc)
+			value, err = p.skipAndExpandCharacterRefs(lookupAttributeValueSingle, lookupAttributeValueSingleNoProc)
 		} else if q == '"' {
-			value = p.skipAndExpandCharacterRefs(lookupAttributeValueDouble, lookupAttributeValueDoubleNoProc)
+			value, err = p.skipAndExpandCharacterRefs(lookupAttributeValueDouble, lookupAttributeValueDoubleNoProc)
 		} else {
 			panic("should never happen")
 		}
-		if value == nil {
-			return fmt.Errorf("error parsing attribute value")
+		if err != nil {
+			return err
 		}
 		// Set attribute value.
 		attrNode.Value = value
 		// Make sure end quote is present.
 		if p.currentByte() != q {
-			return fmt.Errorf("expected %v as end quote", q)
+			return p.expected(fmt.Sprintf("%q", rune(q)))
 		}
 		element.AppendChild(attrNode)
-		p.position++             // Skip quote.
+		p.position++ // Skip quote.
+		setSpan(attrNode, attrStart, p.position)
 		p.skip(lookupWhitespace) // Skip whitespace after attribute value.
 	}
 	return nil
@@ -390,6 +442,9 @@ func (p *XDiff) parseElement() (*xtree.Node, error) {
 	if err != nil {
 		return nil, err
 	}
+	if p.position >= p.len {
+		return nil, ErrUnexpectedEnd
+	}
 	// Ending type.
 	c := p.currentByte()
 	if c == '>' {
@@ -399,7 +454,7 @@ func (p *XDiff) parseElement() (*xtree.Node, error) {
 		}
 	} else if c == '/' {
 		if p.nextByte() != '>' {
-			return nil, fmt.Errorf("expected '>' after '/' at position %v", p.position)
+			return nil, p.expected("'>'")
 		}
 		p.position++
 	} else {
@@ -412,6 +467,9 @@ func (p *XDiff) parseElement() (*xtree.Node, error) {
 func (p *XDiff) parseNodeContents(cn *xtree.Node) error {
 	for {
 		p.skip(lookupWhitespace)
+		if p.position >= p.len {
+			return ErrUnexpectedEnd
+		}
 		if p.currentByte() == '<' {
 			if p.nextByte() == '/' {
 				p.position++
@@ -420,24 +478,34 @@ func (p *XDiff) parseNodeContents(cn *xtree.Node) error {
 					p.skip(lookupNodeName)
 					closeTag := p.sliceFrom(start)
 					if bytes.Compare(closeTag, cn.Name) != 0 {
-						return fmt.Errorf("unexpected closing tag got '%s', expected '%s'", closeTag, cn.Name)
+						return &SyntaxError{
+							Msg:      fmt.Sprintf("unexpected closing tag got '%s', expected '%s'", closeTag, cn.Name),
+							Expected: "</" + string(cn.Name) + ">",
+							Found:    "</" + string(closeTag) + ">",
+						}
 					}
 				} else {
 					p.skip(lookupNodeName)
 				}
 				p.skip(lookupWhitespace)
 				if p.currentByte() != '>' {
-					return fmt.Errorf("expected '>'")
+					return p.expected("'>'")
 				}
 				p.position++ // Skip '>'.
 				return nil
 			}
 			// Recursive call.
+			start := p.position - 1
 			parsed, err := p.parseNode()
 			if err != nil {
 				return err
 			}
+			setSpan(parsed, start, p.position)
 			cn.AppendChild(parsed)
+		} else if name, ok := p.markupReference(); ok {
+			if err := p.parseEntityContent(cn, name); err != nil {
+				return err
+			}
 		} else {
 			err := p.parseAndAppendData(cn)
 			if err != nil {
@@ -457,6 +525,9 @@ func (p *XDiff) parseDocType() (*xtree.Node, error) {
 		if p.currentByte() == '[' { // beginning of elements
 			p.skipBytes(1) // skip the '['
 			for depth, insideElement := 1, false; depth > 0; {
+				if p.position >= p.len {
+					return nil, ErrUnexpectedEnd
+				}
 				switch p.currentByte() {
 				case '[':
 					if !insideElement { // only count if not in a quote
@@ -483,7 +554,8 @@ func (p *XDiff) parseDocType() (*xtree.Node, error) {
 	}
 	dt := xtree.NewNode(xtree.Doctype)
 	dt.Value = p.sliceFrom(start)
-	p.skipBytes(1)
+	p.declareEntities(dt.Value)
+	p.position++ // Skip '>'.
 	return dt, nil
 }
 
@@ -491,11 +563,12 @@ func (p *XDiff) parseXMLDeclaration() (*xtree.Node, error) {
 	nd := xtree.NewNode(xtree.Declaration)
 	nd.Name = []byte("xml")
 	p.skip(lookupWhitespace)
-	p.parseAttributes(nd)
+	if err := p.parseAttributes(nd); err != nil {
+		return nil, err
+	}
 	// expect closing tags after attributes
 	if !bytes.HasPrefix(p.sliceToEnd(), []byte("?>")) {
-		p.position += 2
-		return nil, fmt.Errorf("unexpected end of xml declaration. Expected '?>'")
+		return nil, p.expected("'?>'")
 	}
 	p.position += 2
 	return nd, nil
@@ -524,12 +597,13 @@ func (p *XDiff) parseProcInstr() (*xtree.Node, error) {
 // parseCDATA creates a CDATA node
 func (p *XDiff) parseCDATA() (*xtree.Node, error) {
 	start := p.position // expects after <![CDATA[
-	err := p.skipToChars([]byte("]]"))
+	err := p.skipToChars([]byte("]]>"))
 	if err != nil {
 		return nil, err
 	}
 	cd := xtree.NewNode(xtree.CData)
 	cd.Value = p.sliceFrom(start)
+	p.position += 3 // skip ]]>
 	return cd, nil
 }
 
@@ -552,11 +626,16 @@ func (p *XDiff) parseComment() (*xtree.Node, error) {
 	comment := xtree.NewNode(xtree.Comment)
 	comment.Value = p.data[start : p.position-2]
 
-	p.skipBytes(1)
+	p.position++ // Skip '>'.
 	return comment, nil
 }
 
+// currentByte returns the byte at the current position, or zero byte at
+// the end of the data.
 func (p *XDiff) currentByte() byte {
+	if p.position >= p.len {
+		return 0
+	}
 	return p.data[p.position]
 }
 
@@ -618,7 +697,6 @@ func (p *XDiff) skip(table *[256]byte) {
 			return
 		}
 	}
-	p.position-- // Avoid crash at the end of data.
 }
 
 func (p *XDiff) sliceFrom(start int) []byte {
@@ -638,78 +716,115 @@ func (p *XDiff) sliceToEnd() []byte {
 
 // skipAndExpandCharacterRefs is used to parse both attribute values and node data while expanding entities
 // since this function can overwrite the buffer, it returns a slice of the active area.
-func (p *XDiff) skipAndExpandCharacterRefs(stopPred, stopPredPure *[256]byte) []byte {
+func (p *XDiff) skipAndExpandCharacterRefs(stopPred, stopPredPure *[256]byte) ([]byte, error) {
 	start := p.position
 	p.skip(stopPredPure) // Fast path if no '&' is found.
 	trail := p.position
+	// Value is copied out of the data once the replacement gets longer
+	// than the reference.
+	var out []byte
 	for c := p.currentByte(); stopPred[c] == 1; {
 		if c == '&' {
-			c = p.nextByte()
-			switch c {
-			case 'a': // &amp; &apos;
-				if err := p.skipBytes(1); err == nil && bytes.HasPrefix(p.sliceToEnd(), []byte("mp;")) {
-					p.position += 2
-				} else if bytes.HasPrefix(p.sliceToEnd(), []byte("pos;")) {
-					p.data[trail] = '\\' // overwrite
-					p.position += 3
+			if name, ok := p.markupReference(); ok {
+				// Markup of the entities is parsed only in the text,
+				// where the value ends before the reference.
+				if stopPred != lookupText {
+					return nil, &SyntaxError{Msg: fmt.Sprintf("'<' in attribute value from entity &%s;", name)}
 				}
-			case 'q': // &quot;
-				if err := p.skipBytes(1); err == nil && bytes.HasPrefix(p.sliceToEnd(), []byte("uot;")) {
-					p.position += 3
-				}
-			case 'g': // &gt;
-				if err := p.skipBytes(1); err == nil && bytes.HasPrefix(p.sliceToEnd(), []byte("t;")) {
-					p.data[trail] = '>' // overwrite
-					p.position++
+				break
+			}
+			value, n, err := p.reference()
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				if out == nil && trail+len(value) > p.position+n {
+					out = append([]byte{}, p.data[start:trail]...)
 				}
-			case 'l': // &lt;
-				if err := p.skipBytes(1); err == nil && bytes.HasPrefix(p.sliceToEnd(), []byte("t;")) {
-					p.data[trail] = '<' // overwrite
-					p.position++
+				if out != nil {
+					out = append(out, value...)
+				} else {
+					trail += copy(p.data[trail:], value)
 				}
-			default:
-				trail++ // In case we can't find any entity move after to p.position
-			case 0:
-				panic("end of data")
+				p.position += n
+				c = p.currentByte()
+				continue
 			}
-			// &#...; - assumes ASCII -- not implemented
-		} else if trail < p.position {
-			// If trail is lagging the position, we need to copy.
-			p.data[trail] = p.data[p.position]
 		}
-		if c = p.nextByte(); c == 0 {
-			return nil // error
+		if out != nil {
+			out = append(out, c)
+		} else {
+			p.data[trail] = c
+			trail++
 		}
-		trail++
+		c = p.nextByte()
+	}
+	if out != nil {
+		return out, nil
 	}
-	return p.data[start:trail]
+	return p.data[start:trail], nil
+}
+
+// reference returns the replacement of the character or entity reference at
+// the current position and the length of the reference. Length is 0 if the
+// reference is kept unexpanded, for entities declared outside of the
+// document.
+func (p *XDiff) reference() ([]byte, int, error) {
+	end := bytes.IndexByte(p.sliceForward(maxReferenceSize), ';')
+	if end < 0 {
+		return nil, 0, &SyntaxError{Msg: "unterminated reference, expected ';'"}
+	}
+	name := p.data[p.position+1 : p.position+end]
+	if len(name) > 0 && name[0] == '#' {
+		r, ok := charRef(name)
+		if !ok {
+			return nil, 0, &SyntaxError{Msg: fmt.Sprintf("invalid character reference &%s;", name)}
+		}
+		n := utf8.EncodeRune(p.runeBuf[:], r)
+		return p.runeBuf[:n], end + 1, nil
+	}
+	if !isName(name) {
+		return nil, 0, &SyntaxError{Msg: fmt.Sprintf("invalid entity name %q", name)}
+	}
+	if value, ok := predefinedEntities[string(name)]; ok {
+		return value, end + 1, nil
+	}
+	if _, ok := p.entities.values[string(name)]; ok {
+		value, err := p.expandEntity(string(name))
+		return value, end + 1, err
+	}
+	if p.entities.external[string(name)] || p.entities.undeclared {
+		return nil, 0, nil
+	}
+	return nil, 0, &SyntaxError{Msg: fmt.Sprintf("undeclared entity &%s;", name)}
 }
 
 // parseAndAppendData adds a data node to the parent node.
 func (p *XDiff) parseAndAppendData(parent *xtree.Node) error {
-	value := p.skipAndExpandCharacterRefs(lookupText, lookupTextNoProc)
-	if value == nil {
-		return fmt.Errorf("unable to append data node")
+	start := p.position
+	value, err := p.skipAndExpandCharacterRefs(lookupText, lookupTextNoProc)
+	if err != nil {
+		return err
+	}
+	if p.position == start {
+		// Character not allowed in the text.
+		return p.expected("character data")
 	}
 	n := xtree.NewNode(xtree.Data)
 	n.Value = value
+	setSpan(n, start, p.position)
 	parent.AppendChild(n)
 	return nil
 }
 
+// expected returns the error of unexpected token at the current position.
+func (p *XDiff) expected(token string) error {
+	return &SyntaxError{Expected: token}
+}
+
+// wrapError returns the syntax error at the current position caused by v.
 func (p *XDiff) wrapError(v error) error {
-	const contextSize = 40
-	start := max(p.position-contextSize, 0)
-	stop := min(p.position, p.len)
-	leftcontext := p.data[start:stop]
-	start = min(p.position+1, p.len)
-	stop = min(p.position+contextSize, p.len)
-	rightcontext := p.data[start:stop]
-	if p.position > p.len-1 {
-		return fmt.Errorf("parser: %v\n%v", v, string(leftcontext))
-	}
-	return fmt.Errorf("parser: %v\n%v{%s}%v", v, string(leftcontext), string(p.currentByte()),
-		string(rightcontext))
+	return newSyntaxError(v, p.data, p.lines, p.position)
 }
 
 func max(x, y int) int {
@@ -725,6 +840,3 @@ func min(x, y int) int {
 	}
 	return y
 }
-
-// ErrUnexpectedEnd represents attempted read beyond available data.
-var ErrUnexpectedEnd = errors.New("parser: unexpected end of data")
@@ -1,80 +1,13 @@
 package parser
 
 import (
+	"errors"
 	"os"
-	"path/filepath"
-	"strings"
 	"testing"
 
 	"github.com/ajankovic/xdiff/xtree"
 )
 
-func TestParserValidFiles(t *testing.T) {
-	t.Skip()
-	p := New()
-	testdirs := []string{
-		"testfiles/xmlconf/ibm/valid/*/*.xml",
-		"testfiles/xmlconf/japanese/*.xml",
-		"testfiles/xmlconf/oasis/*/*.xml",
-		"testfiles/xmlconf/sun/valid/sa/*.xml",
-		"testfiles/xmlconf/xmltest/valid/*.xml",
-	}
-	exclude := []string{
-		"weekly-iso-2022-jp.xml", // not supported encoding
-		"pr-xml-iso-2022-jp.xml", // not supported encoding
-		"pr-xml-utf-16.xml",      // TODO needs more work
-		"weekly-utf-16.xml",      // TODO needs more work
-	}
-	for _, pat := range testdirs {
-		fs, err := filepath.Glob(pat)
-		if err != nil {
-			t.Fatal(err)
-		}
-		for _, f := range fs {
-			if inSlice(f, exclude) {
-				continue
-			}
-			_, err := p.ParseFile(f)
-			if err != nil {
-				t.Errorf("Expected valid file %s got error\n%v", f, err)
-			}
-		}
-	}
-}
-
-func TestParserInvalidFiles(t *testing.T) {
-	t.Skip()
-	p := New()
-	testdirs := []string{
-		"testfiles/xmlconf/xmltest/not-wf/sa/*.xml",
-	}
-	exclude := []string{}
-	for _, pat := range testdirs {
-		fs, err := filepath.Glob(pat)
-		if err != nil {
-			t.Fatal(err)
-		}
-		for _, f := range fs {
-			if inSlice(f, exclude) {
-				continue
-			}
-			_, err := p.ParseFile(f)
-			if err == nil {
-				t.Errorf("Expected error with file %s", f)
-			}
-		}
-	}
-}
-
-func inSlice(needle string, sl []string) bool {
-	for _, str := range sl {
-		if strings.Contains(needle, str) {
-			return true
-		}
-	}
-	return false
-}
-
 var basicFilename = "testfiles/basic.xml"
 
 func TestXDiff_ParseReader(t *testing.T) {
@@ -141,3 +74,157 @@ func TestXDiff_ParseDir(t *testing.T) {
 		t.Errorf("Expected first child of the root to be named 'b' got %s", n.FirstChild.Name)
 	}
 }
+
+func TestParseNamespaces(t *testing.T) {
+	data := `<b:books xmlns:b="urn:books" xmlns="urn:default"><b:book b:id="1" lang="en"><title>T</title></b:book></b:books>`
+	want := []struct {
+		path string
+		name string
+	}{
+		{"/b:books[1]", "{urn:books}books"},
+		{"/b:books[1]/@xmlns:b", "{" + xtree.XMLNSNamespace + "}b"},
+		{"/b:books[1]/b:book[1]", "{urn:books}book"},
+		{"/b:books[1]/b:book[1]/@b:id", "{urn:books}id"},
+		{"/b:books[1]/b:book[1]/@lang", "lang"},
+		{"/b:books[1]/b:book[1]/title[1]", "{urn:default}title"},
+	}
+	parsers := map[string]func([]byte) (*xtree.Node, error){
+		"XDiff":    New().ParseBytes,
+		"Standard": NewStandard().ParseBytes,
+	}
+	for name, parse := range parsers {
+		t.Run(name, func(t *testing.T) {
+			doc, err := parse([]byte(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range want {
+				n, err := doc.Find(w.path)
+				if err != nil {
+					t.Errorf("Find(%q) error %v", w.path, err)
+					continue
+				}
+				if got := string(n.ExpandedName()); got != w.name {
+					t.Errorf("ExpandedName() of %s = %s, want %s", w.path, got, w.name)
+				}
+			}
+			title, _ := doc.Find("/b:books[1]/b:book[1]/title[1]")
+			if title != nil && string(title.Signature) != "/{urn:books}books/{urn:books}book/{urn:default}title/Element" {
+				t.Errorf("Signature of title = %s", title.Signature)
+			}
+		})
+	}
+}
+
+func TestParsersIdenticalTrees(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"predefined entities", `<a v="&lt;&gt;&amp;&apos;&quot;">&lt;b&gt; &amp; &apos;c&apos; &quot;d&quot;</a>`},
+		{"decimal references", `<a v="caf&#233;">&#72;&#105;&#33; &#8364;</a>`},
+		{"hex references", `<a v='&#x20AC;&#x41;'>&#x20AC;&#x1F600;&#xe9;</a>`},
+		{"single quoted", `<a v='&apos;x&apos; "y"'>&quot;</a>`},
+		{"escaped reference", `<a v="&amp;lt;">&amp;#65;</a>`},
+		{"nested elements", `<?xml version="1.0"?><r><a x="&#49;">1&#10;2</a><!-- c --><b>&amp;</b></r>`},
+		{"processing instruction", `<r><?pi  data ?>x</r>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fast, err := New().ParseBytes([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			standard, err := NewStandard().ParseBytes([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path, ok := identicalTrees(fast, standard); !ok {
+				ft, _ := xtree.TextString(fast)
+				st, _ := xtree.TextString(standard)
+				t.Errorf("XDiff tree\n%s\ndiffers from Standard tree at %s\n%s", ft, path, st)
+			}
+		})
+	}
+}
+
+// identicalTrees walks both xtrees in lockstep and compares type, name,
+// value and number of children of every node. It returns path of the first
+// node that differs.
+func identicalTrees(a, b *xtree.Node) (string, bool) {
+	for s := [][2]*xtree.Node{{a, b}}; len(s) > 0; {
+		n, m := s[len(s)-1][0], s[len(s)-1][1]
+		s = s[:len(s)-1]
+		if n.Type != m.Type || string(n.Name) != string(m.Name) || string(n.Value) != string(m.Value) ||
+			len(n.Children()) != len(m.Children()) {
+			return n.Path(), false
+		}
+		for ch, mch := n.FirstChild, m.FirstChild; ch != nil; ch, mch = ch.NextSibling, mch.NextSibling {
+			s = append(s, [2]*xtree.Node{ch, mch})
+		}
+	}
+	return "", true
+}
+
+func TestParsersRejectReferences(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"bare ampersand in attribute", `<a b="&"/>`},
+		{"bare ampersand in text", `<a>x & y</a>`},
+		{"missing semicolon", `<a b="&amp"/>`},
+		{"missing semicolon at the end", `<a>&amp`},
+		{"empty name", `<a>&;</a>`},
+		{"invalid name", `<a>&1a;</a>`},
+		{"undeclared entity", `<a>&foo;</a>`},
+		{"invalid character reference", `<a>&#xZZ;</a>`},
+		{"disallowed character", `<a b="&#0;"/>`},
+		{"trailing '<'", `<a b="&"><`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().ParseBytes([]byte(tt.data))
+			var se *SyntaxError
+			if !errors.As(err, &se) {
+				t.Errorf("XDiff error = %v, want SyntaxError", err)
+			}
+			if _, err := NewStandard().ParseBytes([]byte(tt.data)); err == nil {
+				t.Errorf("Standard accepted %s", tt.data)
+			}
+		})
+	}
+}
+
+func TestXDiffUnexpectedEnd(t *testing.T) {
+	tests := []string{
+		`<a><`,
+		`<a>x<`,
+		`<a b="x"><`,
+		`<0 0="`,
+		`<a b='x`,
+		`<a`,
+		`<a `,
+		`<a/`,
+		`<a></a`,
+		`<!`,
+		`<!-`,
+		`<!-->`,
+		`<![CDATA[`,
+		`<!DOCTYPE`,
+		`<!DOCTYPE a [`,
+		`<!DOCTYPE a [<!ENTITY x "y">`,
+		`<?xml`,
+		`<?xml version="1.0"`,
+		`<?pi`,
+	}
+	for _, data := range tests {
+		t.Run(data, func(t *testing.T) {
+			_, err := New().ParseBytes([]byte(data))
+			var se *SyntaxError
+			if !errors.As(err, &se) {
+				t.Errorf("ParseBytes(%q) error = %v, want SyntaxError", data, err)
+			}
+		})
+	}
+}
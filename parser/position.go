@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"sort"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// lineIndex maps byte offsets of the document to the lines and columns. It
+// holds offsets of the line starts in the document converted to UTF-8 by
+// toUTF8, which keeps the lines of the source, and the map of the offsets
+// back to the source.
+type lineIndex struct {
+	starts []int
+	source offsetMap
+}
+
+// newLineIndex creates index of the lines in the document converted from the
+// source with the offset map.
+func newLineIndex(b []byte, source offsetMap) lineIndex {
+	li := lineIndex{starts: []int{0}, source: source}
+	for i, c := range b {
+		if c == '\n' {
+			li.starts = append(li.starts, i+1)
+		}
+	}
+	return li
+}
+
+// position returns the position in the source at the offset.
+func (li lineIndex) position(offset int) xtree.Position {
+	line := sort.SearchInts(li.starts, offset+1)
+	start := li.starts[line-1]
+	if li.source != nil {
+		offset, start = li.source.offset(offset), li.source.offset(start)
+	}
+	return xtree.Position{
+		Offset: offset,
+		Line:   line,
+		Column: offset - start + 1,
+	}
+}
+
+// offsetMap maps the offsets of the document converted to UTF-8 to the
+// offsets in the source. It holds spans of the characters converted from the
+// same number of bytes to the same number of bytes.
+type offsetMap []offsetSpan
+
+// offsetSpan is the run of the characters of the converted document starting
+// at the offset, each of size bytes converted from srcSize bytes of the
+// source starting at src.
+type offsetSpan struct {
+	offset, size int
+	src, srcSize int
+}
+
+// add adds the character at the offset converted from the source at src.
+func (m *offsetMap) add(offset, src, size, srcSize int) {
+	if size == 0 {
+		return
+	}
+	if n := len(*m); n > 0 {
+		last := (*m)[n-1]
+		if last.size == size && last.srcSize == srcSize &&
+			last.src+(offset-last.offset)/size*srcSize == src {
+			return
+		}
+	}
+	*m = append(*m, offsetSpan{offset: offset, size: size, src: src, srcSize: srcSize})
+}
+
+// offset returns the offset in the source of the offset in the converted
+// document. Offsets inside of a character are mapped to its start.
+func (m offsetMap) offset(offset int) int {
+	i := sort.Search(len(m), func(i int) bool { return m[i].offset > offset }) - 1
+	if i < 0 {
+		return offset
+	}
+	s := m[i]
+	return s.src + (offset-s.offset)/s.size*s.srcSize
+}
+
+// setPositions sets lines and columns of all nodes in the xtree rooted at n
+// from the offsets of their positions.
+func (li lineIndex) setPositions(n *xtree.Node) {
+	s := []*xtree.Node{n}
+	for len(s) > 0 {
+		n := s[len(s)-1]
+		s = s[:len(s)-1]
+		n.Start = li.position(n.Start.Offset)
+		n.End = li.position(n.End.Offset)
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			s = append(s, ch)
+		}
+	}
+}
+
+// setSpan sets offsets of the node's positions.
+func setSpan(n *xtree.Node, start, end int) {
+	if n == nil {
+		return
+	}
+	n.Start.Offset = start
+	n.End.Offset = end
+}
+
+// attributeSpans returns offsets of the attributes in the start tag of the
+// element or the XML declaration, relative to the start of the tag.
+func attributeSpans(tag []byte) [][2]int {
+	var spans [][2]int
+	i := 1
+	if i < len(tag) && tag[i] == '?' {
+		i++
+	}
+	for i < len(tag) && lookupNodeName[tag[i]] == 1 {
+		i++
+	}
+	for {
+		for i < len(tag) && lookupWhitespace[tag[i]] == 1 {
+			i++
+		}
+		if i >= len(tag) || lookupAttributeName[tag[i]] != 1 {
+			return spans
+		}
+		start := i
+		for i < len(tag) && tag[i] != '=' {
+			i++
+		}
+		for i < len(tag) && tag[i] != '"' && tag[i] != '\'' {
+			i++
+		}
+		if i >= len(tag) {
+			return spans
+		}
+		q := tag[i]
+		for i++; i < len(tag) && tag[i] != q; i++ {
+		}
+		if i >= len(tag) {
+			return spans
+		}
+		i++
+		spans = append(spans, [2]int{start, i})
+	}
+}
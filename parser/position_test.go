@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+const positionsXML = `<?xml version="1.0"?>
+<!-- c -->
+<root id="1">
+  <a b='x &amp; y'>text</a>
+  <![CDATA[<raw>]]>
+  <?pi value?>
+  <e/>
+</root>`
+
+func TestPositions(t *testing.T) {
+	tests := []struct {
+		path       string
+		start, end string
+		source     string
+	}{
+		{"/", "1:1", "8:8", positionsXML},
+		{"/declaration()[1]", "1:1", "1:22", `<?xml version="1.0"?>`},
+		{"/declaration()[1]/@version", "1:7", "1:20", `version="1.0"`},
+		{"/comment()[1]", "2:1", "2:11", "<!-- c -->"},
+		{"/root[1]", "3:1", "8:8", positionsXML[33:]},
+		{"/root[1]/@id", "3:7", "3:13", `id="1"`},
+		{"/root[1]/a[1]", "4:3", "4:28", `<a b='x &amp; y'>text</a>`},
+		{"/root[1]/a[1]/@b", "4:6", "4:19", `b='x &amp; y'`},
+		{"/root[1]/a[1]/text()[1]", "4:20", "4:24", "text"},
+		{"/root[1]/text()[1]", "5:3", "5:20", "<![CDATA[<raw>]]>"},
+		{"/root[1]/processing-instruction(pi)[1]", "6:3", "6:15", "<?pi value?>"},
+		{"/root[1]/e[1]", "7:3", "7:7", "<e/>"},
+	}
+	parsers := map[string]func([]byte) (*xtree.Node, error){
+		"XDiff":    New().ParseBytes,
+		"Standard": NewStandard().ParseBytes,
+	}
+	for name, parse := range parsers {
+		t.Run(name, func(t *testing.T) {
+			doc, err := parse([]byte(positionsXML))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tt := range tests {
+				n, err := doc.Find(tt.path)
+				if err != nil {
+					t.Errorf("Find(%q) error %v", tt.path, err)
+					continue
+				}
+				if n.Start.String() != tt.start || n.End.String() != tt.end {
+					t.Errorf("%s at %s-%s, want %s-%s", tt.path, n.Start, n.End, tt.start, tt.end)
+				}
+				if got := positionsXML[n.Start.Offset:n.End.Offset]; got != tt.source {
+					t.Errorf("%s source %q, want %q", tt.path, got, tt.source)
+				}
+			}
+		})
+	}
+}
+
+func TestPositionsConverted(t *testing.T) {
+	// utf16 returns the string encoded with UTF-16LE with the byte order
+	// mark.
+	utf16 := func(s string) []byte {
+		b := []byte{0xFF, 0xFE}
+		for _, r := range s {
+			b = append(b, byte(r), byte(r>>8))
+		}
+		return b
+	}
+	tests := []struct {
+		name string
+		data []byte
+		// Bytes per character of the source, except for "é".
+		size int
+	}{
+		// Latin-1 "é" is a single byte converted to two bytes of UTF-8.
+		{"ISO-8859-1", []byte("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<a>\xe9</a>\n<b/>"), 1},
+		{"UTF-16", utf16("<?xml version=\"1.0\" encoding=\"UTF-16\"?>\n<a>é</a>\n<b/>"), 2},
+	}
+	parsers := map[string]func([]byte) (*xtree.Node, error){
+		"XDiff":    New().ParseBytes,
+		"Standard": NewStandard().ParseBytes,
+	}
+	for name, parse := range parsers {
+		for _, tt := range tests {
+			t.Run(name+" "+tt.name, func(t *testing.T) {
+				doc, err := parse(tt.data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Positions are in the bytes of the source.
+				n, err := doc.Find("/b[1]")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := len(tt.data) - 4*tt.size; n.Start.String() != "3:1" || n.Start.Offset != want {
+					t.Errorf("/b[1] at %s offset %d, want 3:1 offset %d", n.Start, n.Start.Offset, want)
+				}
+				n, err = doc.Find("/a[1]")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := fmt.Sprintf("2:%d", 8*tt.size+1); n.End.String() != want {
+					t.Errorf("/a[1] ends at %s, want %s", n.End, want)
+				}
+				n, err = doc.Find("/a[1]/text()[1]")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := fmt.Sprintf("2:%d", 3*tt.size+1); n.Start.String() != want {
+					t.Errorf("/a[1]/text()[1] at %s, want %s", n.Start, want)
+				}
+			})
+		}
+	}
+}
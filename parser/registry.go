@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// Parser is implemented by the parsers able to generate xtrees from the xml
+// documents and the directories containing them.
+type Parser interface {
+	// ParseReader returns the document node parsed from the reader.
+	ParseReader(r io.Reader) (*xtree.Node, error)
+	// ParseBytes returns the document node parsed from the bytes.
+	ParseBytes(b []byte) (*xtree.Node, error)
+	// ParseFile returns the document node parsed from the file.
+	ParseFile(filepath string) (*xtree.Node, error)
+	// ParseDir returns the directory node with the parsed documents of the
+	// directory.
+	ParseDir(dirpath string) (*xtree.Node, error)
+}
+
+var (
+	_ Parser = (*XDiff)(nil)
+	_ Parser = (*Standard)(nil)
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]func() Parser{
+		"fast":     func() Parser { return New() },
+		"standard": func() Parser { return NewStandard() },
+	}
+)
+
+// Register makes the parser available by the name. Parsers keep the state of
+// the parsing so the constructor is called on every lookup. Register panics
+// if the constructor is nil or the name is already registered.
+func Register(name string, constructor func() Parser) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if constructor == nil {
+		panic("parser: Register constructor is nil")
+	}
+	if _, ok := registry[name]; ok {
+		panic("parser: Register called twice for parser " + name)
+	}
+	registry[name] = constructor
+}
+
+// Lookup returns new instance of the parser registered by the name, "fast"
+// for the XDiff parser and "standard" for the Standard one.
+func Lookup(name string) (Parser, error) {
+	registryMu.RLock()
+	constructor, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("parser: unknown parser %q, expected %s", name, strings.Join(Names(), " or "))
+	}
+	return constructor(), nil
+}
+
+// Names returns the sorted names of the registered parsers.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		want Parser
+		err  string
+	}{
+		{"fast", New(), ""},
+		{"standard", NewStandard(), ""},
+		{"sax", nil, `parser: unknown parser "sax"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Lookup(tt.name)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("Lookup() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reflect.TypeOf(p) != reflect.TypeOf(tt.want) {
+				t.Errorf("Lookup() = %T, want %T", p, tt.want)
+			}
+			if other, _ := Lookup(tt.name); other == p {
+				t.Errorf("Lookup() returned the same instance twice")
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("test-register", func() Parser { return NewStandard() })
+	p, err := Lookup("test-register")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*Standard); !ok {
+		t.Errorf("Lookup() = %T, want *Standard", p)
+	}
+	names := strings.Join(Names(), ",")
+	if !strings.Contains(names, "test-register") {
+		t.Errorf("Names() = %s, want it to contain test-register", names)
+	}
+	for _, name := range []string{"fast", "test-register"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) twice didn't panic", name)
+				}
+			}()
+			Register(name, func() Parser { return New() })
+		}()
+	}
+}
@@ -3,7 +3,10 @@ package parser
 import (
 	"bytes"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
@@ -16,6 +19,9 @@ import (
 type Standard struct {
 	// Handler for non-xml files encountered during directory traversal.
 	NonXMLHandler func(f *os.File, fi os.FileInfo) (*xtree.Node, error)
+	// Normalizer of the text and attribute values applied before the parsed
+	// xtree is prepared. Values are kept as parsed if it's nil.
+	Normalizer *Normalizer
 }
 
 // NewStandard instantiates new standard parser.
@@ -26,33 +32,61 @@ func NewStandard() *Standard {
 // ParseReader returns reference to the document node got by parsing bytes from the provided
 // reader.
 func (p *Standard) ParseReader(r io.Reader) (*xtree.Node, error) {
-	dec := xml.NewDecoder(r)
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return p.ParseBytes(b)
+}
+
+// ParseBytes returns reference to the document node parsed from provided bytes.
+func (p *Standard) ParseBytes(b []byte) (*xtree.Node, error) {
+	b, _, offsets, err := toUTF8(b)
+	if err != nil {
+		return nil, err
+	}
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	dec.CharsetReader = charsetReader
+	lines := newLineIndex(b, offsets)
 	doc := xtree.NewNode(xtree.Document)
+	setSpan(doc, 0, len(b))
 	current := doc
 	for {
+		start := int(dec.InputOffset())
 		tok, err := dec.Token()
 		if err != nil && err != io.EOF {
-			return nil, err
+			return nil, newSyntaxError(standardError(err), b, lines, int(dec.InputOffset()))
 		}
 		if tok == nil {
 			break
 		}
+		end := int(dec.InputOffset())
 		switch el := tok.(type) {
 		case xml.StartElement:
+			// Names are taken from the source since the decoder replaces
+			// their prefixes with the namespaces.
 			child := &xtree.Node{
 				Type: xtree.Element,
-				Name: []byte(el.Name.Local),
+				Name: qualifiedName(b[start+1:end], el.Name, lookupNodeName),
 			}
-			for _, a := range el.Attr {
-				child.AppendChild(&xtree.Node{
+			spans := attributeSpans(b[start:end])
+			for i, a := range el.Attr {
+				attr := &xtree.Node{
 					Type:  xtree.Attribute,
 					Name:  []byte(a.Name.Local),
 					Value: []byte(a.Value),
-				})
+				}
+				if i < len(spans) {
+					attr.Name = qualifiedName(b[start+spans[i][0]:start+spans[i][1]], a.Name, lookupAttributeName)
+					setSpan(attr, start+spans[i][0], start+spans[i][1])
+				}
+				child.AppendChild(attr)
 			}
+			setSpan(child, start, end)
 			current.AppendChild(child)
 			current = child
 		case xml.EndElement:
+			current.End.Offset = end
 			current = current.Parent
 		case xml.CharData:
 			content := make([]byte, len(el))
@@ -64,6 +98,7 @@ func (p *Standard) ParseReader(r io.Reader) (*xtree.Node, error) {
 				Type:  xtree.Data,
 				Value: content,
 			}
+			setSpan(child, start, end)
 			current.AppendChild(child)
 		case xml.Comment:
 			content := make([]byte, len(el))
@@ -72,6 +107,7 @@ func (p *Standard) ParseReader(r io.Reader) (*xtree.Node, error) {
 				Type:  xtree.Comment,
 				Value: content,
 			}
+			setSpan(child, start, end)
 			current.AppendChild(child)
 		case xml.Directive:
 			content := make([]byte, len(el))
@@ -80,6 +116,7 @@ func (p *Standard) ParseReader(r io.Reader) (*xtree.Node, error) {
 				Type:  xtree.Doctype,
 				Value: content,
 			}
+			setSpan(child, start, end)
 			current.AppendChild(child)
 		case xml.ProcInst:
 			var child *xtree.Node
@@ -92,26 +129,64 @@ func (p *Standard) ParseReader(r io.Reader) (*xtree.Node, error) {
 				}
 				attrs, err := p.parseAttributes(content)
 				if err != nil {
-					return nil, err
+					return nil, newSyntaxError(fmt.Errorf("invalid xml declaration: %v", err), b, lines, start)
 				}
-				for _, attr := range attrs {
+				spans := attributeSpans(b[start:end])
+				for i, attr := range attrs {
+					if i < len(spans) {
+						setSpan(attr, start+spans[i][0], start+spans[i][1])
+					}
 					child.AppendChild(attr)
 				}
 			} else {
 				child = &xtree.Node{
 					Type:  xtree.ProcInstr,
-					Value: append([]byte(el.Target), el.Inst...),
+					Name:  []byte(el.Target),
+					Value: bytes.TrimLeft(append([]byte{}, el.Inst...), xmlWhitespace),
 				}
 			}
+			setSpan(child, start, end)
 			current.AppendChild(child)
 		}
 	}
+	lines.setPositions(doc)
+	xtree.ResolveNamespaces(doc)
+	if p.Normalizer != nil {
+		p.Normalizer.Normalize(doc)
+	}
 	if err := xtree.Prepare(doc); err != nil {
 		return doc, err
 	}
 	return doc, nil
 }
 
+// qualifiedName returns the name made of the table's characters at the start
+// of the source, or the local part of the decoded name if it doesn't match
+// the source.
+func qualifiedName(source []byte, name xml.Name, table *[256]byte) []byte {
+	i := 0
+	for i < len(source) && table[source[i]] == 1 {
+		i++
+	}
+	if !bytes.HasSuffix(source[:i], []byte(name.Local)) {
+		return []byte(name.Local)
+	}
+	return append([]byte{}, source[:i]...)
+}
+
+// standardError returns the error of encoding/xml without its line, which
+// is part of the syntax error's position.
+func standardError(err error) error {
+	var se *xml.SyntaxError
+	if !errors.As(err, &se) {
+		return err
+	}
+	if se.Msg == "unexpected EOF" {
+		return &SyntaxError{Msg: se.Msg, Found: "EOF", Err: err}
+	}
+	return &SyntaxError{Msg: se.Msg, Err: err}
+}
+
 // parseAttributes is  parsing declaration attributes since standard library
 // parses it only as byte content.
 //
@@ -149,7 +224,9 @@ func (p *Standard) ParseFile(filepath string) (*xtree.Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	return p.ParseReader(f)
+	defer f.Close()
+	n, err := p.ParseReader(f)
+	return n, withFile(err, filepath)
 }
 
 // ParseDir returns reference to the directory node got by parsing provided dirpath.
@@ -207,24 +284,40 @@ func (p *Standard) parseDirFile(filepath string, fi os.FileInfo) (*xtree.Node, e
 	defer f.Close()
 
 	b := make([]byte, 4)
-	_, err = f.Read(b)
+	read, err := f.Read(b)
 	if err == io.EOF {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	b, i := ensureUTF8(b)
+	size := fi.Size() + bytes.MinRead
+	b, i, _, err := toUTF8(b[:read])
+	if err != nil {
+		// Decoding fails if the first bytes end inside of a character, so
+		// the error is returned only if the whole file can't be decoded.
+		if _, err = f.Seek(0, 0); err != nil {
+			return nil, err
+		}
+		if b, err = readAll(f, size); err != nil {
+			return nil, err
+		}
+		if b, i, _, err = toUTF8(b); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath, err)
+		}
+	}
 	// Reset reader.
 	_, err = f.Seek(0, 0)
 	if err != nil {
 		return nil, err
 	}
-	size := fi.Size() + bytes.MinRead
 	var n *xtree.Node
-	if b[i] == '<' {
-		b, err = readAll(f, size)
+	if i < len(b) && b[i] == '<' {
+		if b, err = readAll(f, size); err != nil {
+			return nil, err
+		}
 		n, err = p.ParseBytes(b)
+		err = withFile(err, filepath)
 	} else if p.NonXMLHandler != nil {
 		n, err = p.NonXMLHandler(f, fi)
 	}
@@ -233,8 +326,3 @@ func (p *Standard) parseDirFile(filepath string, fi os.FileInfo) (*xtree.Node, e
 	}
 	return n, err
 }
-
-// ParseBytes returns reference to the document node parsed from provided bytes.
-func (p *Standard) ParseBytes(b []byte) (*xtree.Node, error) {
-	return p.ParseReader(bytes.NewBuffer(b))
-}
@@ -2,69 +2,11 @@ package parser
 
 import (
 	"os"
-	"path/filepath"
 	"testing"
 
 	"github.com/ajankovic/xdiff/xtree"
 )
 
-func TestStandardValidFiles(t *testing.T) {
-	t.Skip()
-	p := NewStandard()
-	testdirs := []string{
-		"testfiles/xmlconf/ibm/valid/*/*.xml",
-		"testfiles/xmlconf/japanese/*.xml",
-		"testfiles/xmlconf/oasis/*/*.xml",
-		"testfiles/xmlconf/sun/valid/sa/*.xml",
-		"testfiles/xmlconf/xmltest/valid/*.xml",
-	}
-	exclude := []string{
-		"weekly-iso-2022-jp.xml", // not supported encoding
-		"pr-xml-iso-2022-jp.xml", // not supported encoding
-		"pr-xml-utf-16.xml",      // TODO needs more work
-		"weekly-utf-16.xml",      // TODO needs more work
-	}
-	for _, pat := range testdirs {
-		fs, err := filepath.Glob(pat)
-		if err != nil {
-			t.Fatal(err)
-		}
-		for _, f := range fs {
-			if inSlice(f, exclude) {
-				continue
-			}
-			_, err := p.ParseFile(f)
-			if err != nil {
-				t.Errorf("Expected valid file %s got error\n%v", f, err)
-			}
-		}
-	}
-}
-
-func TestStandardInvalidFiles(t *testing.T) {
-	t.Skip()
-	p := NewStandard()
-	testdirs := []string{
-		"testfiles/xmlconf/xmltest/not-wf/sa/*.xml",
-	}
-	exclude := []string{}
-	for _, pat := range testdirs {
-		fs, err := filepath.Glob(pat)
-		if err != nil {
-			t.Fatal(err)
-		}
-		for _, f := range fs {
-			if inSlice(f, exclude) {
-				continue
-			}
-			_, err := p.ParseFile(f)
-			if err == nil {
-				t.Errorf("Expected error with file %s", f)
-			}
-		}
-	}
-}
-
 func TestStandard_ParseReader(t *testing.T) {
 	p := NewStandard()
 	f, err := os.Open(basicFilename)
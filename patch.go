@@ -0,0 +1,1064 @@
+package xdiff
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// XMLPatchEncoder knows how to convert edit script to the XML patch
+// document defined by RFC 5261.
+//
+// Operations of the patch are applied one after the other, so selectors of
+// the later operations depend on the changes made by the earlier ones. That's
+// why the encoder needs the left xtree, it runs the edit script on its copy
+// and takes the selectors of the nodes as they are changed. Moves and
+// reorders are written as removal and addition of the node since the patch
+// has no operation to move the node.
+type XMLPatchEncoder struct {
+	w    *bufio.Writer
+	left *xtree.Node
+}
+
+// NewXMLPatchEncoder creates new XML patch encoder for the edit scripts
+// generated from the left xtree.
+func NewXMLPatchEncoder(w io.Writer, left *xtree.Node) *XMLPatchEncoder {
+	return &XMLPatchEncoder{bufio.NewWriter(w), left}
+}
+
+// Encode sends edit script as XML patch document to the stream.
+//
+// Names of the namespaced elements and attributes are prefixed by the
+// namespaces declared on the diff element, since XPath doesn't use the
+// default namespace. Prefixes of the documents are kept where they are bound
+// to a single namespace, the rest of the namespaces get generated prefixes.
+func (pe *XMLPatchEncoder) Encode(deltas []Delta) error {
+	tree := pe.left.Clone()
+	ops := patchEditor{&bytes.Buffer{}, tree, newPatchNamespaces(tree, deltas)}
+	if err := edit(tree, deltas, ops); err != nil {
+		return err
+	}
+	pe.w.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<diff")
+	prefixes := make([]string, 0, len(ops.ns.uris))
+	for prefix := range ops.ns.uris {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		fmt.Fprintf(pe.w, " xmlns:%s=\"%s\"", prefix, attrEscaper.Replace(ops.ns.uris[prefix]))
+	}
+	pe.w.WriteString(">\n")
+	pe.w.Write(ops.w.Bytes())
+	pe.w.WriteString("</diff>\n")
+	return pe.w.Flush()
+}
+
+// patchEditor writes patch operation for every change it makes. Changes of
+// the detached nodes are not written, they are part of the contents added
+// once the nodes are placed back.
+type patchEditor struct {
+	w    *bytes.Buffer
+	tree *xtree.Node
+	ns   *patchNamespaces
+}
+
+// attached returns true if the node is part of the patched xtree.
+func (pe patchEditor) attached(n *xtree.Node) bool {
+	return inTree(pe.tree, n)
+}
+
+func (pe patchEditor) Update(n *xtree.Node, value []byte) error {
+	if !pe.attached(n) {
+		return treeEditor{}.Update(n, value)
+	}
+	sel, err := pe.selector(n)
+	if err != nil {
+		return err
+	}
+	n.Value = append([]byte{}, value...)
+	content := textEscaper.Replace(string(value))
+	if n.Type != xtree.Attribute {
+		if content, err = pe.content(n); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(pe.w, "  <replace sel=\"%s\">%s</replace>\n", attrEscaper.Replace(sel), content)
+	return nil
+}
+
+func (pe patchEditor) Rename(n *xtree.Node, name []byte) error {
+	if !pe.attached(n) {
+		return treeEditor{}.Rename(n, name)
+	}
+	sel, err := pe.selector(n)
+	if err != nil {
+		return err
+	}
+	switch n.Type {
+	case xtree.Element:
+		n.Name = append([]byte{}, name...)
+		content, err := pe.content(n)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(pe.w, "  <replace sel=\"%s\">%s</replace>\n", attrEscaper.Replace(sel), content)
+		return nil
+	case xtree.Attribute:
+		parent, err := pe.selector(n.Parent)
+		if err != nil {
+			return err
+		}
+		n.Name = append([]byte{}, name...)
+		fmt.Fprintf(pe.w, "  <remove sel=\"%s\"/>\n", attrEscaper.Replace(sel))
+		fmt.Fprintf(pe.w, "  <add sel=\"%s\" type=\"@%s\">%s</add>\n",
+			attrEscaper.Replace(parent), pe.ns.name(n), textEscaper.Replace(string(n.Value)))
+		return nil
+	}
+	return fmt.Errorf("xdiff: can't rename %s in XML patch", n)
+}
+
+func (pe patchEditor) Remove(n *xtree.Node) error {
+	if !pe.attached(n) {
+		return treeEditor{}.Remove(n)
+	}
+	sel, err := pe.selector(n)
+	if err != nil {
+		return err
+	}
+	n.Remove()
+	fmt.Fprintf(pe.w, "  <remove sel=\"%s\"/>\n", attrEscaper.Replace(sel))
+	return nil
+}
+
+func (pe patchEditor) Insert(parent, n, before *xtree.Node) error {
+	if !pe.attached(parent) {
+		return treeEditor{}.Insert(parent, n, before)
+	}
+	sel, err := pe.selector(parent)
+	if err != nil {
+		return err
+	}
+	if n.Type == xtree.Attribute {
+		parent.InsertBefore(n, before)
+		fmt.Fprintf(pe.w, "  <add sel=\"%s\" type=\"@%s\">%s</add>\n",
+			attrEscaper.Replace(sel), pe.ns.name(n), textEscaper.Replace(string(n.Value)))
+		return nil
+	}
+	// Attributes have no position in XML so the node is added before the
+	// first sibling which isn't an attribute.
+	for before != nil && before.Type == xtree.Attribute {
+		before = before.NextSibling
+	}
+	pos := ""
+	if before != nil {
+		if sel, err = pe.selector(before); err != nil {
+			return err
+		}
+		pos = " pos=\"before\""
+	}
+	parent.InsertBefore(n, before)
+	content, err := pe.content(n)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(pe.w, "  <add sel=\"%s\"%s>%s</add>\n", attrEscaper.Replace(sel), pos, content)
+	return nil
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;",
+		"\n", "&#xA;", "\r", "&#xD;", "\t", "&#x9;")
+)
+
+// selector returns XPath selector of the node.
+func (pe patchEditor) selector(n *xtree.Node) (string, error) {
+	var steps []string
+	for ; n.Parent != nil; n = n.Parent {
+		step, err := pe.step(n)
+		if err != nil {
+			return "", err
+		}
+		steps = append(steps, step)
+	}
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return "/" + strings.Join(steps, "/"), nil
+}
+
+// step returns location step of the node. Elements are counted among the
+// siblings with the same namespace and local name.
+func (pe patchEditor) step(n *xtree.Node) (string, error) {
+	switch n.Type {
+	case xtree.Attribute:
+		return "@" + pe.ns.name(n), nil
+	case xtree.Element:
+		count := 1
+		for prev := n.PrevSibling(); prev != nil; prev = prev.PrevSibling() {
+			if sameName(prev, n) {
+				count++
+			}
+		}
+		return pe.ns.name(n) + "[" + strconv.Itoa(count) + "]", nil
+	}
+	steps := n.Parent.ChildSteps()
+	step := steps[position(n)]
+	switch {
+	case strings.HasPrefix(step, "processing-instruction("):
+		end := strings.LastIndex(step, ")")
+		step = "processing-instruction('" + step[len("processing-instruction("):end] + "')" + step[end+1:]
+	case !strings.HasPrefix(step, "text(") && !strings.HasPrefix(step, "comment("):
+		return "", fmt.Errorf("xdiff: node %q can't be selected in XML patch", n.Path())
+	}
+	return step, nil
+}
+
+// sameName returns true if the elements or attributes have the same
+// namespace and local name, or the same names if their prefixes are not
+// declared.
+func sameName(a, b *xtree.Node) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	uriA, okA := namespaceOf(a)
+	uriB, okB := namespaceOf(b)
+	if !okA || !okB {
+		return !okA && !okB && bytes.Equal(a.Name, b.Name)
+	}
+	return uriA == uriB && bytes.Equal(a.LocalName(), b.LocalName())
+}
+
+// patchNamespaces are the namespaces declared by the patch document along
+// with their prefixes.
+type patchNamespaces struct {
+	// bound are the namespaces bound to the prefixes used by the documents.
+	bound    map[string]map[string]bool
+	prefixes map[string]string
+	uris     map[string]string
+}
+
+// newPatchNamespaces creates namespaces of the patch for the edit script run
+// on the xtree.
+func newPatchNamespaces(tree *xtree.Node, deltas []Delta) *patchNamespaces {
+	ns := &patchNamespaces{
+		bound:    make(map[string]map[string]bool),
+		prefixes: make(map[string]string),
+		uris:     make(map[string]string),
+	}
+	ns.collect(tree)
+	for _, d := range deltas {
+		if d.Right.Node != nil {
+			ns.collect(d.Right.Node)
+		}
+	}
+	return ns
+}
+
+// collect records the prefixes used in the xtree and the namespaces they are
+// bound to.
+func (ns *patchNamespaces) collect(tree *xtree.Node) {
+	for s := []*xtree.Node{tree}; len(s) > 0; {
+		n := s[len(s)-1]
+		s = s[:len(s)-1]
+		s = append(s, n.Children()...)
+		if n.Type != xtree.Element && n.Type != xtree.Attribute {
+			continue
+		}
+		prefix := string(n.Prefix())
+		if n.IsNamespaceDeclaration() {
+			prefix = declaredPrefix(n)
+		}
+		if ns.bound[prefix] == nil {
+			ns.bound[prefix] = make(map[string]bool)
+		}
+		if n.IsNamespaceDeclaration() {
+			ns.bound[prefix][string(n.Value)] = true
+		}
+	}
+}
+
+// prefix returns the prefix declared by the patch for the namespace. Prefix
+// of the document is kept if the documents bind it only to the namespace,
+// otherwise the unused one is generated.
+func (ns *patchNamespaces) prefix(uri, prefix string) string {
+	if uri == xtree.XMLNamespace {
+		return "xml"
+	}
+	if p, ok := ns.prefixes[uri]; ok {
+		return p
+	}
+	_, taken := ns.uris[prefix]
+	if taken || prefix == "" || prefix == "xml" || prefix == "xmlns" ||
+		len(ns.bound[prefix]) != 1 || !ns.bound[prefix][uri] {
+		for i := 1; ; i++ {
+			prefix = "ns" + strconv.Itoa(i)
+			_, used := ns.bound[prefix]
+			if _, taken := ns.uris[prefix]; !used && !taken {
+				break
+			}
+		}
+	}
+	ns.prefixes[uri], ns.uris[prefix] = prefix, uri
+	return prefix
+}
+
+// name returns name of the element or attribute prefixed as declared by the
+// patch. Namespace declarations and names with undeclared prefixes are kept
+// as they are.
+func (ns *patchNamespaces) name(n *xtree.Node) string {
+	uri, ok := namespaceOf(n)
+	if !ok || uri == "" || n.IsNamespaceDeclaration() {
+		return string(n.Name)
+	}
+	return ns.prefix(uri, string(n.Prefix())) + ":" + string(n.LocalName())
+}
+
+// namespaceOf returns namespace of the element or attribute bound by the
+// xmlns declarations in its scope. Namespaces are looked up in the xtree as
+// it is, since the patch changes the declarations along the way. It returns
+// false if the prefix of the name is not declared.
+func namespaceOf(n *xtree.Node) (string, bool) {
+	switch {
+	case n.IsNamespaceDeclaration():
+		return xtree.XMLNSNamespace, true
+	case n.Type == xtree.Element:
+		return lookupNamespace(n, string(n.Prefix()))
+	case n.Type == xtree.Attribute && n.Prefix() != nil:
+		return lookupNamespace(n.Parent, string(n.Prefix()))
+	}
+	return "", true
+}
+
+// lookupNamespace returns namespace bound to the prefix in scope of the
+// element, or to the default namespace if the prefix is empty.
+func lookupNamespace(el *xtree.Node, prefix string) (string, bool) {
+	switch prefix {
+	case "xml":
+		return xtree.XMLNamespace, true
+	case "xmlns":
+		return xtree.XMLNSNamespace, true
+	}
+	for ; el != nil; el = el.Parent {
+		for ch := el.FirstChild; ch != nil && ch.Type == xtree.Attribute; ch = ch.NextSibling {
+			if ch.IsNamespaceDeclaration() && declaredPrefix(ch) == prefix {
+				return string(ch.Value), prefix == "" || len(ch.Value) > 0
+			}
+		}
+	}
+	return "", prefix == ""
+}
+
+// declaredPrefix returns the prefix declared by the xmlns attribute, which is
+// empty for the default namespace.
+func declaredPrefix(decl *xtree.Node) string {
+	if decl.Prefix() == nil {
+		return ""
+	}
+	return string(decl.LocalName())
+}
+
+// content returns XML of the subtree rooted at n, with the names prefixed as
+// declared by the patch.
+func (pe patchEditor) content(n *xtree.Node) (string, error) {
+	var b strings.Builder
+	var write func(n *xtree.Node) error
+	write = func(n *xtree.Node) error {
+		switch n.Type {
+		case xtree.Element:
+			name := pe.ns.name(n)
+			b.WriteString("<")
+			b.WriteString(name)
+			ch := n.FirstChild
+			for ; ch != nil && ch.Type == xtree.Attribute; ch = ch.NextSibling {
+				fmt.Fprintf(&b, " %s=\"%s\"", pe.ns.name(ch), attrEscaper.Replace(string(ch.Value)))
+			}
+			if ch == nil {
+				b.WriteString("/>")
+				return nil
+			}
+			b.WriteString(">")
+			for ; ch != nil; ch = ch.NextSibling {
+				if err := write(ch); err != nil {
+					return err
+				}
+			}
+			b.WriteString("</")
+			b.WriteString(name)
+			b.WriteString(">")
+		case xtree.Data:
+			b.WriteString(textEscaper.Replace(string(n.Value)))
+		case xtree.CData:
+			b.WriteString("<![CDATA[")
+			b.WriteString(strings.Replace(string(n.Value), "]]>", "]]]]><![CDATA[>", -1))
+			b.WriteString("]]>")
+		case xtree.Comment:
+			b.WriteString("<!--")
+			b.Write(n.Value)
+			b.WriteString("-->")
+		case xtree.ProcInstr:
+			fmt.Fprintf(&b, "<?%s %s?>", n.Name, n.Value)
+		default:
+			return fmt.Errorf("xdiff: %s can't be written in XML patch", n)
+		}
+		return nil
+	}
+	if err := write(n); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// XMLPatch is the XML patch document defined by RFC 5261.
+//
+// Selectors of the operations support absolute location paths with child and
+// attribute steps. Steps can select elements by name or "*", "text()",
+// "comment()", "processing-instruction()" and "node()", and can be filtered
+// by positional predicates like [2] and [last()] or comparisons like
+// [@id='1'] and [name='value']. Prefixes of the names are resolved by the
+// namespaces declared in the patch document, and unprefixed names select
+// the nodes without namespace as they do in XPath.
+type XMLPatch struct {
+	ops []patchOp
+}
+
+// patchOp is the single operation of the XML patch.
+type patchOp struct {
+	name    string
+	sel     string
+	pos     string
+	typ     string
+	ws      string
+	content *xtree.Node
+	// scope are the namespaces declared for the selector by their prefixes.
+	scope map[string]string
+}
+
+// XMLPatchDecoder knows how to read XML patch documents.
+type XMLPatchDecoder struct {
+	r io.Reader
+}
+
+// NewXMLPatchDecoder creates new XML patch decoder.
+func NewXMLPatchDecoder(r io.Reader) *XMLPatchDecoder {
+	return &XMLPatchDecoder{r}
+}
+
+// Decode reads XML patch document from the stream.
+func (pd *XMLPatchDecoder) Decode() (*XMLPatch, error) {
+	dec := xml.NewDecoder(pd.r)
+	patch := &XMLPatch{}
+	depth := 0
+	scope := map[string]string{"xml": xtree.XMLNamespace, "xmlns": xtree.XMLNSNamespace}
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			if depth != 0 {
+				return nil, fmt.Errorf("xdiff: unexpected end of XML patch")
+			}
+			return patch, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xdiff: invalid XML patch: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				scope = declare(scope, t.Attr)
+				depth++
+				continue
+			}
+			op := patchOp{name: t.Name.Local, scope: declare(scope, t.Attr)}
+			if op.name != "add" && op.name != "replace" && op.name != "remove" {
+				return nil, fmt.Errorf("xdiff: unknown XML patch operation %q", op.name)
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space != "" {
+					continue
+				}
+				switch a.Name.Local {
+				case "sel":
+					op.sel = a.Value
+				case "pos":
+					op.pos = a.Value
+				case "type":
+					op.typ = a.Value
+				case "ws":
+					op.ws = a.Value
+				}
+			}
+			if op.sel == "" {
+				return nil, fmt.Errorf("xdiff: XML patch operation %s without selector", op.name)
+			}
+			if op.content, err = patchNodes(dec, op.scope); err != nil {
+				return nil, err
+			}
+			patch.ops = append(patch.ops, op)
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) != 0 {
+				return nil, fmt.Errorf("xdiff: unexpected text %q in XML patch", t)
+			}
+		}
+	}
+}
+
+// patchNodes reads contents of the patch operation up to its end element.
+// Returned node holds the contents as its children. Whitespace between the
+// nodes is dropped as it is by the parsers, unless the contents are just
+// text. Elements and attributes keep their names as they are in the patch,
+// with the namespaces resolved in the scope of the operation.
+func patchNodes(dec *xml.Decoder, scope map[string]string) (*xtree.Node, error) {
+	holder := xtree.NewNode(xtree.Element)
+	current := holder
+	scopes := []map[string]string{scope}
+	for {
+		tok, err := dec.RawToken()
+		if err != nil {
+			return nil, fmt.Errorf("xdiff: invalid XML patch: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			scope := declare(scopes[len(scopes)-1], t.Attr)
+			el := &xtree.Node{
+				Type:      xtree.Element,
+				Name:      []byte(rawName(t.Name)),
+				Namespace: resolveName(t.Name, scope, false),
+			}
+			for _, a := range t.Attr {
+				el.AppendChild(&xtree.Node{
+					Type:      xtree.Attribute,
+					Name:      []byte(rawName(a.Name)),
+					Value:     []byte(a.Value),
+					Namespace: resolveName(a.Name, scope, true),
+				})
+			}
+			current.AppendChild(el)
+			current = el
+			scopes = append(scopes, scope)
+		case xml.EndElement:
+			if current != holder {
+				current = current.Parent
+				scopes = scopes[:len(scopes)-1]
+				continue
+			}
+			if !isText(holder) {
+				for ch := holder.FirstChild; ch != nil; {
+					next := ch.NextSibling
+					if ch.Type == xtree.Data && len(bytes.TrimSpace(ch.Value)) == 0 {
+						ch.Remove()
+					}
+					ch = next
+				}
+			}
+			return holder, nil
+		case xml.CharData:
+			if current != holder && len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+			if last := current.LastChild(); last != nil && last.Type == xtree.Data {
+				last.Value = append(last.Value, t...)
+				continue
+			}
+			current.AppendChild(&xtree.Node{Type: xtree.Data, Value: append([]byte{}, t...)})
+		case xml.Comment:
+			current.AppendChild(&xtree.Node{Type: xtree.Comment, Value: append([]byte{}, t...)})
+		case xml.ProcInst:
+			current.AppendChild(&xtree.Node{
+				Type:  xtree.ProcInstr,
+				Name:  []byte(t.Target),
+				Value: bytes.TrimLeft(append([]byte{}, t.Inst...), " \t\r\n"),
+			})
+		case xml.Directive:
+			return nil, fmt.Errorf("xdiff: unexpected directive in XML patch")
+		}
+	}
+}
+
+// rawName returns the name with its namespace prefix.
+func rawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// declare returns the scope with the namespaces declared by the attributes.
+// Scope is copied only if it changes.
+func declare(scope map[string]string, attrs []xml.Attr) map[string]string {
+	copied := false
+	for _, a := range attrs {
+		prefix := a.Name.Local
+		if a.Name.Space == "" && a.Name.Local == "xmlns" {
+			prefix = ""
+		} else if a.Name.Space != "xmlns" {
+			continue
+		}
+		if !copied {
+			outer := scope
+			scope = make(map[string]string, len(outer)+1)
+			for p, uri := range outer {
+				scope[p] = uri
+			}
+			copied = true
+		}
+		scope[prefix] = a.Value
+	}
+	return scope
+}
+
+// resolveName returns namespace of the element or attribute name in the
+// scope, or nil if it has none or its prefix is not declared. Unprefixed
+// attributes have no namespace.
+func resolveName(name xml.Name, scope map[string]string, attr bool) []byte {
+	switch {
+	case attr && (name.Space == "xmlns" || name.Space == "" && name.Local == "xmlns"):
+		return []byte(xtree.XMLNSNamespace)
+	case attr && name.Space == "":
+		return nil
+	}
+	if uri := scope[name.Space]; uri != "" {
+		return []byte(uri)
+	}
+	return nil
+}
+
+// isText returns true if the node has only text children.
+func isText(n *xtree.Node) bool {
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		if ch.Type != xtree.Data {
+			return false
+		}
+	}
+	return true
+}
+
+// text returns the text contents of the patch operation.
+func (op patchOp) text() ([]byte, error) {
+	if !isText(op.content) {
+		return nil, fmt.Errorf("xdiff: %s of %q expects text", op.name, op.sel)
+	}
+	var value []byte
+	for ch := op.content.FirstChild; ch != nil; ch = ch.NextSibling {
+		value = append(value, ch.Value...)
+	}
+	return value, nil
+}
+
+// Apply changes the xtree by running the operations of the patch. Namespaces,
+// signatures and hashes of the xtree are recalculated.
+func (p *XMLPatch) Apply(tree *xtree.Node) error {
+	for tree.Parent != nil {
+		tree = tree.Parent
+	}
+	for _, op := range p.ops {
+		if err := op.apply(tree); err != nil {
+			return err
+		}
+	}
+	xtree.ResolveNamespaces(tree)
+	return xtree.Prepare(tree)
+}
+
+// apply runs the patch operation on the xtree.
+func (op patchOp) apply(tree *xtree.Node) error {
+	target, err := selectNode(tree, op.sel, op.scope)
+	if err != nil {
+		return err
+	}
+	switch op.name {
+	case "add":
+		return op.add(target)
+	case "replace":
+		return op.replace(target)
+	}
+	if target.Parent == nil {
+		return fmt.Errorf("xdiff: remove of the root %q", op.sel)
+	}
+	var whitespace []*xtree.Node
+	for _, side := range []struct {
+		name    string
+		sibling *xtree.Node
+	}{{"before", target.PrevSibling()}, {"after", target.NextSibling}} {
+		if op.ws != side.name && op.ws != "both" {
+			continue
+		}
+		if side.sibling == nil || side.sibling.Type != xtree.Data || len(bytes.TrimSpace(side.sibling.Value)) != 0 {
+			return fmt.Errorf("xdiff: no whitespace %s %q", side.name, op.sel)
+		}
+		whitespace = append(whitespace, side.sibling)
+	}
+	for _, n := range whitespace {
+		n.Remove()
+	}
+	target.Remove()
+	return nil
+}
+
+// add adds contents of the operation relative to the target node.
+func (op patchOp) add(target *xtree.Node) error {
+	if strings.HasPrefix(op.typ, "@") {
+		if target.Type != xtree.Element {
+			return fmt.Errorf("xdiff: add of attribute to %q which is not an element", op.sel)
+		}
+		name := op.typ[1:]
+		for ch := target.FirstChild; ch != nil && ch.Type == xtree.Attribute; ch = ch.NextSibling {
+			if matchTest(ch, op.typ, op.scope) {
+				return fmt.Errorf("xdiff: attribute %s of %q already exists", name, op.sel)
+			}
+		}
+		value, err := op.text()
+		if err != nil {
+			return err
+		}
+		attr := &xtree.Node{Type: xtree.Attribute, Name: []byte(name), Value: value}
+		if i := strings.IndexByte(name, ':'); i > 0 {
+			attr.Namespace = resolveName(xml.Name{Space: name[:i], Local: name[i+1:]}, op.scope, true)
+		}
+		target.InsertBefore(attr, firstContent(target))
+		adopt(attr)
+		return nil
+	}
+	if op.typ != "" {
+		return fmt.Errorf("xdiff: unsupported add type %q", op.typ)
+	}
+	parent, before := target, (*xtree.Node)(nil)
+	switch op.pos {
+	case "":
+	case "prepend":
+		before = firstContent(target)
+	case "before", "after":
+		if target.Parent == nil || target.Type == xtree.Attribute {
+			return fmt.Errorf("xdiff: add %s %q which has no siblings", op.pos, op.sel)
+		}
+		parent, before = target.Parent, target
+		if op.pos == "after" {
+			before = target.NextSibling
+		}
+	default:
+		return fmt.Errorf("xdiff: invalid add position %q", op.pos)
+	}
+	if parent.Type != xtree.Element && parent.Type != xtree.Document {
+		return fmt.Errorf("xdiff: add into %q which is not an element", op.sel)
+	}
+	for ch := op.content.FirstChild; ch != nil; ch = ch.NextSibling {
+		n := ch.Clone()
+		parent.InsertBefore(n, before)
+		adopt(n)
+	}
+	return nil
+}
+
+// replace replaces the target node with contents of the operation.
+func (op patchOp) replace(target *xtree.Node) error {
+	switch target.Type {
+	case xtree.Attribute, xtree.Data, xtree.CData:
+		value, err := op.text()
+		if err != nil {
+			return err
+		}
+		target.Value = value
+		return nil
+	case xtree.Element, xtree.Comment, xtree.ProcInstr:
+		n := op.content.FirstChild
+		if n == nil || n.NextSibling != nil || n.Type != target.Type {
+			return fmt.Errorf("xdiff: replace of %q expects single %s", op.sel, target.Type)
+		}
+		if target.Type != xtree.Element {
+			target.Name, target.Value = n.Name, n.Value
+			return nil
+		}
+		if target.Parent == nil {
+			return fmt.Errorf("xdiff: replace of the root %q", op.sel)
+		}
+		n = n.Clone()
+		target.Parent.InsertBefore(n, target)
+		target.Remove()
+		adopt(n)
+		return nil
+	}
+	return fmt.Errorf("xdiff: replace of %q which is %s", op.sel, target.Type)
+}
+
+// adopt renames the added elements and attributes so they keep the
+// namespaces they have in the patch once they are in the xtree. Prefixes
+// bound to the namespaces in their scope are used, otherwise the prefixes of
+// the patch are declared.
+func adopt(n *xtree.Node) {
+	switch n.Type {
+	case xtree.Attribute:
+		adoptName(n, n.Parent)
+	case xtree.Element:
+		children := n.Children()
+		adoptName(n, n)
+		for _, ch := range children {
+			adopt(ch)
+		}
+	}
+}
+
+// adoptName renames the added element or attribute of the element.
+func adoptName(n, el *xtree.Node) {
+	prefix := string(n.Prefix())
+	if n.IsNamespaceDeclaration() || n.Type == xtree.Attribute && prefix == "" {
+		return
+	}
+	if prefix != "" && n.Namespace == nil {
+		// Undeclared prefix is kept as it is.
+		return
+	}
+	uri := string(n.Namespace)
+	if bound, ok := lookupNamespace(el, prefix); ok && bound == uri {
+		return
+	}
+	if p, ok := boundPrefix(el, uri, n.Type == xtree.Element); ok {
+		n.Name = append([]byte(p+":"), n.LocalName()...)
+		if p == "" {
+			n.Name = n.Name[1:]
+		}
+		return
+	}
+	decl := "xmlns"
+	if prefix != "" {
+		decl += ":" + prefix
+	}
+	el.InsertBefore(&xtree.Node{
+		Type:      xtree.Attribute,
+		Name:      []byte(decl),
+		Value:     []byte(uri),
+		Namespace: []byte(xtree.XMLNSNamespace),
+	}, firstContent(el))
+}
+
+// boundPrefix returns the prefix bound to the namespace in scope of the
+// element, including the default namespace if it's allowed.
+func boundPrefix(el *xtree.Node, uri string, allowDefault bool) (string, bool) {
+	if uri == "" {
+		return "", false
+	}
+	if bound, _ := lookupNamespace(el, ""); allowDefault && bound == uri {
+		return "", true
+	}
+	for n := el; n != nil; n = n.Parent {
+		for ch := n.FirstChild; ch != nil && ch.Type == xtree.Attribute; ch = ch.NextSibling {
+			prefix := declaredPrefix(ch)
+			if !ch.IsNamespaceDeclaration() || prefix == "" || string(ch.Value) != uri {
+				continue
+			}
+			if bound, _ := lookupNamespace(el, prefix); bound == uri {
+				return prefix, true
+			}
+		}
+	}
+	return "", false
+}
+
+// firstContent returns the first child of the node which isn't attribute.
+func firstContent(n *xtree.Node) *xtree.Node {
+	ch := n.FirstChild
+	for ch != nil && ch.Type == xtree.Attribute {
+		ch = ch.NextSibling
+	}
+	return ch
+}
+
+// selectNode returns the single node of the xtree selected by the XPath.
+func selectNode(tree *xtree.Node, sel string, scope map[string]string) (*xtree.Node, error) {
+	steps, err := splitXPath(sel, '/')
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) < 2 || steps[0] != "" {
+		return nil, fmt.Errorf("xdiff: selector %q is not absolute path", sel)
+	}
+	nodes := []*xtree.Node{tree}
+	if sel != "/" {
+		for _, step := range steps[1:] {
+			if nodes, err = selectStep(nodes, step, scope); err != nil {
+				return nil, fmt.Errorf("xdiff: invalid selector %q: %v", sel, err)
+			}
+		}
+	}
+	if len(nodes) != 1 {
+		return nil, fmt.Errorf("xdiff: selector %q matches %d nodes instead of one", sel, len(nodes))
+	}
+	return nodes[0], nil
+}
+
+// selectStep returns children of the nodes matching the location step.
+func selectStep(nodes []*xtree.Node, step string, scope map[string]string) ([]*xtree.Node, error) {
+	parts, err := splitXPath(step, '[')
+	if err != nil {
+		return nil, err
+	}
+	test := parts[0]
+	if test == "" {
+		return nil, fmt.Errorf("empty step %q", step)
+	}
+	var predicates []string
+	for _, p := range parts[1:] {
+		if !strings.HasPrefix(p, "[") || !strings.HasSuffix(p, "]") {
+			return nil, fmt.Errorf("invalid predicate in step %q", step)
+		}
+		predicates = append(predicates, p[1:len(p)-1])
+	}
+	var selected []*xtree.Node
+	for _, n := range nodes {
+		var children []*xtree.Node
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			if matchTest(ch, test, scope) {
+				children = append(children, ch)
+			}
+		}
+		for _, p := range predicates {
+			if children, err = filterPredicate(children, p, scope); err != nil {
+				return nil, err
+			}
+		}
+		selected = append(selected, children...)
+	}
+	return selected, nil
+}
+
+// filterPredicate returns nodes that satisfy the predicate.
+func filterPredicate(nodes []*xtree.Node, predicate string, scope map[string]string) ([]*xtree.Node, error) {
+	predicate = strings.TrimSpace(predicate)
+	if predicate == "last()" {
+		if len(nodes) == 0 {
+			return nil, nil
+		}
+		return nodes[len(nodes)-1:], nil
+	}
+	if i, err := strconv.Atoi(predicate); err == nil {
+		if i < 1 || i > len(nodes) {
+			return nil, nil
+		}
+		return nodes[i-1 : i], nil
+	}
+	test, literal, compare := predicate, "", false
+	if parts, err := splitXPath(predicate, '='); err != nil {
+		return nil, err
+	} else if len(parts) == 2 {
+		test, compare = strings.TrimSpace(parts[0]), true
+		literal = strings.TrimSpace(parts[1])
+		if len(literal) < 2 || (literal[0] != '\'' && literal[0] != '"') || literal[len(literal)-1] != literal[0] {
+			return nil, fmt.Errorf("invalid literal in predicate %q", predicate)
+		}
+		literal = literal[1 : len(literal)-1]
+	} else if len(parts) > 2 {
+		return nil, fmt.Errorf("invalid predicate %q", predicate)
+	}
+	var filtered []*xtree.Node
+	for _, n := range nodes {
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			if matchTest(ch, test, scope) && (!compare || stringValue(ch) == literal) {
+				filtered = append(filtered, n)
+				break
+			}
+		}
+	}
+	return filtered, nil
+}
+
+// matchTest returns true if the node passes the node test of the step.
+func matchTest(n *xtree.Node, test string, scope map[string]string) bool {
+	if strings.HasPrefix(test, "@") {
+		return n.Type == xtree.Attribute && (test == "@*" || matchName(n, test[1:], scope))
+	}
+	switch test {
+	case "*":
+		return n.Type == xtree.Element
+	case "node()":
+		return n.Type != xtree.Attribute
+	case "text()":
+		return n.Type == xtree.Data || n.Type == xtree.CData
+	case "comment()":
+		return n.Type == xtree.Comment
+	}
+	if strings.HasPrefix(test, "processing-instruction(") && strings.HasSuffix(test, ")") {
+		target := strings.Trim(test[len("processing-instruction("):len(test)-1], "'\" ")
+		return n.Type == xtree.ProcInstr && (target == "" || string(n.Name) == target)
+	}
+	return n.Type == xtree.Element && matchName(n, test, scope)
+}
+
+// matchName returns true if the element or attribute has the name of the
+// node test. Prefixed names match by their namespace and local name, and
+// unprefixed ones match the names without namespace. Namespace declarations
+// and the names with prefixes not declared in the scope are compared as they
+// are.
+func matchName(n *xtree.Node, name string, scope map[string]string) bool {
+	if n.IsNamespaceDeclaration() || name == "xmlns" || strings.HasPrefix(name, "xmlns:") {
+		return string(n.Name) == name
+	}
+	uri, local := "", name
+	if i := strings.IndexByte(name, ':'); i > 0 {
+		if uri, local = scope[name[:i]], name[i+1:]; uri == "" {
+			return string(n.Name) == name
+		}
+	}
+	bound, ok := namespaceOf(n)
+	return ok && bound == uri && string(n.LocalName()) == local
+}
+
+// stringValue returns XPath string value of the node.
+func stringValue(n *xtree.Node) string {
+	if n.Type != xtree.Element {
+		return string(n.Value)
+	}
+	var b strings.Builder
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		if ch.Type == xtree.Element || ch.Type == xtree.Data || ch.Type == xtree.CData {
+			b.WriteString(stringValue(ch))
+		}
+	}
+	return b.String()
+}
+
+// splitXPath splits the expression by the separator which is not quoted or
+// inside the predicate. Predicates are split by their opening bracket.
+func splitXPath(expr string, sep byte) ([]string, error) {
+	var parts []string
+	depth, quote, start := 0, byte(0), 0
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '\'' || c == '"':
+			quote = c
+			continue
+		}
+		if c == sep && depth == 0 {
+			parts = append(parts, expr[start:i])
+			start = i
+			if sep != '[' {
+				start++
+			}
+		}
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("xdiff: unbalanced brackets in %q", expr)
+			}
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, fmt.Errorf("xdiff: unbalanced quotes or brackets in %q", expr)
+	}
+	return append(parts, expr[start:]), nil
+}
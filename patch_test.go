@@ -0,0 +1,260 @@
+package xdiff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ajankovic/xdiff/parser"
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+func TestXMLPatchEncoder(t *testing.T) {
+	left := doc("", el("root", attr("id", "1"), el("a", dat("1")), el("b"), el("a", dat("x < y"))))
+	right := doc("", el("root", attr("id", "2"), el("a", dat("1")), el("a", dat("x < y"), el("c", attr("k", "\"v\"")))))
+	xtree.Prepare(left)
+	xtree.Prepare(right)
+	script, err := Compare(left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := NewXMLPatchEncoder(&buf, left).Encode(script); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<replace sel="/root[1]/@id">2</replace>`,
+		`<remove sel="/root[1]/b[1]"/>`,
+		`<add sel="/root[1]/a[2]"><c k="&quot;v&quot;"/></add>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Encode() =\n%s, want it to contain %s", buf.String(), want)
+		}
+	}
+	if !strings.HasPrefix(buf.String(), "<?xml") || !strings.HasSuffix(buf.String(), "</diff>\n") {
+		t.Errorf("Encode() =\n%s, want patch document", buf.String())
+	}
+}
+
+func TestXMLPatchNamespaces(t *testing.T) {
+	left, err := parser.New().ParseBytes([]byte(`<r xmlns="urn:d" xmlns:p="urn:p"><p:e p:a="1"/><f/></r>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	right, err := parser.New().ParseBytes([]byte(`<r xmlns="urn:d" xmlns:p="urn:p"><p:e p:a="2"/><f><g/></f><p:h/></r>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := Compare(left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := NewXMLPatchEncoder(&buf, left).Encode(script); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<diff xmlns:ns1="urn:d" xmlns:p="urn:p">`,
+		`<replace sel="/ns1:r[1]/p:e[1]/@p:a">2</replace>`,
+		`<add sel="/ns1:r[1]/ns1:f[1]"><ns1:g/></add>`,
+		`<add sel="/ns1:r[1]"><p:h/></add>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Encode() =\n%s, want it to contain %s", buf.String(), want)
+		}
+	}
+	patch, err := NewXMLPatchDecoder(bytes.NewReader(buf.Bytes())).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := patch.Apply(left); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(left.Hash, right.Hash) {
+		l, _ := xtree.XMLString(left)
+		r, _ := xtree.XMLString(right)
+		t.Errorf("Apply() =\n%s, want\n%s", l, r)
+	}
+
+	// Prefixes of the patch are declared where the document doesn't bind
+	// them, and unprefixed names are the names without namespace.
+	tree, err := parser.New().ParseBytes([]byte(`<r xmlns="urn:d"><e/></r>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := parser.New().ParseBytes([]byte(`<r xmlns="urn:d"><e q:a="1" xmlns:q="urn:q"><q:x/><y xmlns=""/></e></r>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err = NewXMLPatchDecoder(strings.NewReader(`<diff xmlns:d="urn:d" xmlns:q="urn:q">` +
+		`<add sel="/d:r/d:e" type="@q:a">1</add><add sel="/d:r/d:e"><q:x/><y/></add></diff>`)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := patch.Apply(tree); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.Hash, want.Hash) {
+		got, _ := xtree.XMLString(tree)
+		t.Errorf("Apply() =\n%s, want <r xmlns=\"urn:d\"><e q:a=\"1\" xmlns:q=\"urn:q\"><q:x/><y xmlns=\"\"/></e></r>", got)
+	}
+	patch, err = NewXMLPatchDecoder(strings.NewReader(`<diff><remove sel="/r/e"/></diff>`)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := patch.Apply(tree); err == nil {
+		t.Error("Apply() of unprefixed selector succeeded, want error")
+	}
+}
+
+func TestXMLPatchApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  *xtree.Node
+	}{
+		{"Add element",
+			`<add sel="/root/item[@id='2']"><item id="3"/></add>`,
+			doc("", el("root", el("item", attr("id", "1"), dat("one")), el("item", attr("id", "2"), el("item", attr("id", "3")))))},
+		{"Add before",
+			`<add sel="/root/item[2]" pos="before"><!--c--></add>`,
+			doc("", el("root", el("item", attr("id", "1"), dat("one")), comm("c"), el("item", attr("id", "2"))))},
+		{"Add after",
+			`<add sel="/root/item[1]" pos="after"><new/></add>`,
+			doc("", el("root", el("item", attr("id", "1"), dat("one")), el("new"), el("item", attr("id", "2"))))},
+		{"Prepend",
+			`<add sel="/root/item[1]" pos="prepend"><?pi value?></add>`,
+			doc("", el("root", el("item", attr("id", "1"), proc("pi", "value"), dat("one")), el("item", attr("id", "2"))))},
+		{"Add attribute",
+			`<add sel="/root/item[last()]" type="@name">two</add>`,
+			doc("", el("root", el("item", attr("id", "1"), dat("one")), el("item", attr("id", "2"), attr("name", "two"))))},
+		{"Replace attribute",
+			`<replace sel="/root/item[text()='one']/@id">0</replace>`,
+			doc("", el("root", el("item", attr("id", "0"), dat("one")), el("item", attr("id", "2"))))},
+		{"Replace text",
+			`<replace sel="/root/item[1]/text()">1 &amp; 2</replace>`,
+			doc("", el("root", el("item", attr("id", "1"), dat("1 & 2")), el("item", attr("id", "2"))))},
+		{"Replace element",
+			`<replace sel="/root/*[2]">
+				<other>
+					<x/>
+				</other>
+			</replace>`,
+			doc("", el("root", el("item", attr("id", "1"), dat("one")), el("other", el("x"))))},
+		{"Remove",
+			`<remove sel="/root/item[1]"/><remove sel="/root/item/@id"/>`,
+			doc("", el("root", el("item")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := doc("", el("root", el("item", attr("id", "1"), dat("one")), el("item", attr("id", "2"))))
+			xtree.Prepare(tree)
+			xtree.Prepare(tt.want)
+			patch, err := NewXMLPatchDecoder(strings.NewReader("<diff>" + tt.patch + "</diff>")).Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := patch.Apply(tree); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tree.Hash, tt.want.Hash) {
+				got, _ := xtree.TextString(tree)
+				want, _ := xtree.TextString(tt.want)
+				t.Errorf("Apply() =\n%s, want\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestXMLPatchErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"Unknown operation", `<move sel="/root"/>`},
+		{"Without selector", `<remove/>`},
+		{"Relative selector", `<remove sel="root/item[1]"/>`},
+		{"No match", `<remove sel="/root/none"/>`},
+		{"Many matches", `<remove sel="/root/item"/>`},
+		{"Unbalanced predicate", `<remove sel="/root/item[1"/>`},
+		{"Invalid literal", `<remove sel="/root/item[@id=1]"/>`},
+		{"Existing attribute", `<add sel="/root/item[1]" type="@id">1</add>`},
+		{"Attribute to text", `<add sel="/root/item[1]/text()" type="@id">1</add>`},
+		{"Invalid position", `<add sel="/root/item[1]" pos="inside"><x/></add>`},
+		{"Replace text with element", `<replace sel="/root/item[1]/text()"><x/></replace>`},
+		{"Replace element with text", `<replace sel="/root/item[1]">x</replace>`},
+		{"Remove without whitespace", `<remove sel="/root/item[1]" ws="after"/>`},
+		{"Remove root", `<remove sel="/"/>`},
+		{"Text between operations", `text`},
+		{"Unexpected end", `<remove sel="/root/item[1]"/>`[:10]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := doc("", el("root", el("item", attr("id", "1"), dat("one")), el("item", attr("id", "2"))))
+			xtree.Prepare(tree)
+			patch, err := NewXMLPatchDecoder(strings.NewReader("<diff>" + tt.patch + "</diff>")).Decode()
+			if err == nil {
+				err = patch.Apply(tree)
+			}
+			if err == nil {
+				t.Error("Apply() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestXMLPatchComparedScript(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		for _, mode := range []struct {
+			ordered bool
+			renames bool
+		}{{false, false}, {true, false}, {false, true}, {true, true}} {
+			ordered := mode.ordered
+			t.Run(fmt.Sprintf("Seed %d ordered %v renames %v", seed, mode.ordered, mode.renames), func(t *testing.T) {
+				left, right := randomTrees(seed)
+				// Adjacent text nodes are joined in XML so they can't be
+				// told apart in the patch.
+				if adjacentText(left) || adjacentText(right) {
+					t.Skip("adjacent text nodes")
+				}
+				c := NewComparer()
+				c.Ordered = mode.ordered
+				c.DetectRenames = mode.renames
+				script, err := c.Compare(left, right)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				if err := NewXMLPatchEncoder(&buf, left).Encode(script); err != nil {
+					t.Fatal(err)
+				}
+				patch, err := NewXMLPatchDecoder(bytes.NewReader(buf.Bytes())).Decode()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := patch.Apply(left); err != nil {
+					t.Fatalf("Apply(left, %s) error: %v", buf.String(), err)
+				}
+				if !bytes.Equal(canonicalHash(left, ordered), canonicalHash(right, ordered)) {
+					l, _ := xtree.TextString(left)
+					r, _ := xtree.TextString(right)
+					t.Fatalf("Apply(left, %s) =\n%s, want\n%s", buf.String(), l, r)
+				}
+			})
+		}
+	}
+}
+
+// adjacentText returns true if the xtree has adjacent text nodes.
+func adjacentText(n *xtree.Node) bool {
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		if ch.Type == xtree.Data && ch.NextSibling != nil && ch.NextSibling.Type == xtree.Data {
+			return true
+		}
+		if adjacentText(ch) {
+			return true
+		}
+	}
+	return false
+}
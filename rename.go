@@ -0,0 +1,209 @@
+package xdiff
+
+import (
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// detectRenames pairs deleted and inserted elements or attributes which
+// have matched parents and similar enough contents, and replaces them with
+// renames. Renamed elements are followed by deletions and insertions of
+// the children that are not shared between them.
+func (c *Comparer) detectRenames(script []Delta, minCostM *minCostMatch) []Delta {
+	// Siblings that kept their relative order, by their left parent.
+	kept := make(map[*xtree.Node][]nodePair)
+	inserted := make(map[*xtree.Node][]int)
+	for i, d := range script {
+		if (d.Operation == Insert || d.Operation == InsertSubtree) && renamable(d.Subject) && d.Object != nil {
+			inserted[d.Object] = append(inserted[d.Object], i)
+		}
+	}
+	if len(inserted) == 0 {
+		return script
+	}
+	renamed := make(map[int][]Delta)
+	paired := make(map[int]struct{})
+	for i, d := range script {
+		if (d.Operation != Delete && d.Operation != DeleteSubtree) || !renamable(d.Subject) || d.Object == nil {
+			continue
+		}
+		parent := minCostM.Right(d.Object)
+		if parent == nil {
+			continue
+		}
+		best, bestSim := -1, 0.0
+		for _, j := range inserted[parent] {
+			if _, ok := paired[j]; ok {
+				continue
+			}
+			r := script[j].Subject
+			if r.Type != d.Subject.Type || bytesEqual(r.Name, d.Subject.Name) {
+				continue
+			}
+			if sim := similarity(d.Subject, r); sim >= c.RenameThreshold && sim > bestSim {
+				best, bestSim = j, sim
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		paired[best] = struct{}{}
+		pair := nodePair{d.Subject, script[best].Subject}
+		renamed[i] = c.renameScript(pair.Left, pair.Right)
+		if pair.Left.Type == xtree.Attribute || !c.isOrdered(d.Object) {
+			continue
+		}
+		// Renamed node must be reordered unless it's in the same order as
+		// the siblings that kept their order.
+		pairs, ok := kept[d.Object]
+		if !ok {
+			pairs = keptPairs(d.Object, parent, minCostM)
+		}
+		if inOrder(pairs, pair) {
+			pairs = append(pairs, pair)
+		} else {
+			renamed[i] = append(renamed[i], Delta{Operation: Reorder, Subject: pair.Left, Object: pair.Right})
+		}
+		kept[d.Object] = pairs
+	}
+	result := make([]Delta, 0, len(script))
+	for i, d := range script {
+		if _, ok := paired[i]; ok {
+			continue
+		}
+		if deltas, ok := renamed[i]; ok {
+			result = append(result, deltas...)
+			continue
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// keptPairs returns matched children of the nodes which are not reordered.
+func keptPairs(left, right *xtree.Node, minCostM *minCostMatch) []nodePair {
+	moved := reordered(left, right, minCostM)
+	var pairs []nodePair
+	for l := left.FirstChild; l != nil; l = l.NextSibling {
+		if _, ok := moved[l]; ok || l.Type == xtree.Attribute {
+			continue
+		}
+		if r := minCostM.Right(l); r != nil {
+			pairs = append(pairs, nodePair{l, r})
+		}
+	}
+	return pairs
+}
+
+// inOrder returns true if the pair has the same relative order to every
+// one of the sibling pairs in both xtrees.
+func inOrder(siblings []nodePair, pair nodePair) bool {
+	left, right := position(pair.Left), position(pair.Right)
+	for _, s := range siblings {
+		if (position(s.Left) < left) != (position(s.Right) < right) {
+			return false
+		}
+	}
+	return true
+}
+
+// renamable returns true for the nodes which can be renamed.
+func renamable(n *xtree.Node) bool {
+	return n.Type == xtree.Element || n.Type == xtree.Attribute
+}
+
+// similarity measures how similar are the contents of the nodes regardless
+// of their names. Attributes are similar only if they have the same value,
+// and for elements it's the ratio of identical children.
+func similarity(l, r *xtree.Node) float64 {
+	if l.Type == xtree.Attribute {
+		if bytesEqual(l.Value, r.Value) {
+			return 1
+		}
+		return 0
+	}
+	_, restLeft, restRight := pairChildren(l, r)
+	total := len(l.Children()) + len(r.Children())
+	if total == 0 {
+		return 1
+	}
+	return float64(total-len(restLeft)-len(restRight)) / float64(total)
+}
+
+// renameScript generates deltas for renaming the left node to the right
+// one. Children of the renamed elements are paired by hash value and the
+// unpaired ones are deleted or inserted.
+func (c *Comparer) renameScript(l, r *xtree.Node) []Delta {
+	script := []Delta{{Operation: Rename, Subject: l, Object: r}}
+	if l.Type == xtree.Attribute {
+		return script
+	}
+	pairs, restLeft, restRight := pairChildren(l, r)
+	if c.isOrdered(l) {
+		var lefts, rights []*xtree.Node
+		var ordered []nodePair
+		for _, pair := range pairs {
+			if pair.Left.Type != xtree.Attribute {
+				ordered = append(ordered, pair)
+			}
+		}
+		for _, ch := range l.Children() {
+			if ch.Type != xtree.Attribute {
+				lefts = append(lefts, ch)
+			}
+		}
+		for _, ch := range r.Children() {
+			if ch.Type != xtree.Attribute {
+				rights = append(rights, ch)
+			}
+		}
+		for _, pair := range outOfOrder(ordered, lefts, rights) {
+			script = append(script, Delta{Operation: Reorder, Subject: pair.Left, Object: pair.Right})
+		}
+	}
+	for _, ch := range restLeft {
+		if ch.FirstChild == nil {
+			script = append(script, Delta{Operation: Delete, Subject: ch, Object: l, RightParent: r})
+			continue
+		}
+		script = append(script, Delta{Operation: DeleteSubtree, Subject: ch, Object: l, RightParent: r})
+	}
+	for _, ch := range restRight {
+		if ch.FirstChild == nil {
+			script = append(script, Delta{Operation: Insert, Subject: ch, Object: r, LeftParent: l})
+			continue
+		}
+		script = append(script, Delta{Operation: InsertSubtree, Subject: ch, Object: r, LeftParent: l})
+	}
+	return script
+}
+
+// pairChildren pairs children of the nodes with the same hash value and
+// returns the children that were left unpaired. Signatures are not compared
+// since they differ for the children of the renamed elements.
+func pairChildren(l, r *xtree.Node) ([]nodePair, []*xtree.Node, []*xtree.Node) {
+	identical := make(map[string][]*xtree.Node)
+	var rights []*xtree.Node
+	for ch := r.FirstChild; ch != nil; ch = ch.NextSibling {
+		identical[string(ch.Hash)] = append(identical[string(ch.Hash)], ch)
+		rights = append(rights, ch)
+	}
+	var pairs []nodePair
+	var restLeft, restRight []*xtree.Node
+	paired := make(map[*xtree.Node]struct{})
+	for ch := l.FirstChild; ch != nil; ch = ch.NextSibling {
+		rs := identical[string(ch.Hash)]
+		if len(rs) == 0 {
+			restLeft = append(restLeft, ch)
+			continue
+		}
+		pairs = append(pairs, nodePair{ch, rs[0]})
+		paired[rs[0]] = struct{}{}
+		identical[string(ch.Hash)] = rs[1:]
+	}
+	for _, ch := range rights {
+		if _, ok := paired[ch]; !ok {
+			restRight = append(restRight, ch)
+		}
+	}
+	return pairs, restLeft, restRight
+}
@@ -0,0 +1,200 @@
+package xdiff
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+// TerminalEncoder knows how to convert edit script to the tree printed on
+// the terminal. Tree is the right document with the deleted nodes merged
+// back, showing only the changed nodes at their paths along with some of
+// their unchanged siblings. Skipped siblings are summarized by their number.
+type TerminalEncoder struct {
+	// Context is the number of unchanged siblings printed before and after
+	// every changed node.
+	Context int
+	// Color enables ANSI colors of the changed nodes, by default it's
+	// enabled if the writer is a terminal and NO_COLOR is not set.
+	Color bool
+	// LeftFile and RightFile are the sources of the compared xtrees used
+	// in the locations of the changed nodes.
+	LeftFile, RightFile string
+
+	w     io.Writer
+	right *xtree.Node
+}
+
+// NewTerminalEncoder creates new terminal encoder for the edit scripts
+// generated for the right xtree.
+func NewTerminalEncoder(w io.Writer, right *xtree.Node) *TerminalEncoder {
+	return &TerminalEncoder{
+		Context: 2,
+		Color:   isTerminal(w) && os.Getenv("NO_COLOR") == "",
+		w:       w,
+		right:   right,
+	}
+}
+
+// isTerminal reports if the writer is a character device.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// ANSI colors of the changes.
+const (
+	colorReset   = "\x1b[0m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorMagenta = "\x1b[35m"
+	colorCyan    = "\x1b[36m"
+)
+
+// terminalColors are the colors of the changes by the annotation operation.
+var terminalColors = map[string]string{
+	"insert":  colorGreen,
+	"delete":  colorRed,
+	"update":  colorYellow,
+	"rename":  colorMagenta,
+	"move":    colorCyan,
+	"reorder": colorCyan,
+}
+
+// maxTerminalValue is the maximum number of characters of the printed values.
+const maxTerminalValue = 40
+
+// Encode sends the tree of changes to the stream.
+func (te *TerminalEncoder) Encode(deltas []Delta) error {
+	if len(deltas) == 0 {
+		_, err := fmt.Fprint(te.w, "No difference.\n")
+		return err
+	}
+	merged, err := mergeTree(te.right.Clone(), deltas)
+	if err != nil {
+		return err
+	}
+	visible := make(map[*xtree.Node]bool)
+	colors := make(map[*xtree.Node]string)
+	for _, n := range merged.annotated {
+		for p := n; p != nil && !visible[p]; p = p.Parent {
+			visible[p] = true
+		}
+		prev, next := n.PrevSibling(), n.NextSibling
+		for i := 0; i < te.Context; i++ {
+			if prev != nil {
+				visible[prev] = true
+				prev = prev.PrevSibling()
+			}
+			if next != nil {
+				visible[next] = true
+				next = next.NextSibling
+			}
+		}
+		a := merged.annotations[n][0]
+		colors[n] = terminalColors[a.op]
+		if a.op != "insert" && a.op != "delete" {
+			continue
+		}
+		// Whole inserted and deleted subtrees are shown in their color.
+		for s := []*xtree.Node{n}; len(s) > 0; {
+			d := s[len(s)-1]
+			s = s[:len(s)-1]
+			visible[d] = true
+			colors[d] = colors[n]
+			for ch := d.FirstChild; ch != nil; ch = ch.NextSibling {
+				s = append(s, ch)
+			}
+		}
+	}
+
+	enc := xtree.NewTextEncoder(te.w)
+	enc.Visible = func(n *xtree.Node) bool {
+		return visible[n]
+	}
+	enc.Label = func(n *xtree.Node) string {
+		label := terminalLabel(n)
+		for _, a := range merged.annotations[n] {
+			source := te.RightFile
+			if a.op == "delete" {
+				source = te.LeftFile
+			}
+			label += " " + terminalNote(a, location(source, a.path, n.Start))
+		}
+		if te.Color && colors[n] != "" {
+			return colors[n] + label + colorReset
+		}
+		return label
+	}
+	return enc.Encode(merged.root)
+}
+
+// terminalLabel returns short description of the node.
+func terminalLabel(n *xtree.Node) string {
+	switch n.Type {
+	case xtree.Element:
+		return "<" + string(n.Name) + ">"
+	case xtree.Attribute:
+		return "@" + string(n.Name) + "=" + terminalValue(string(n.Value))
+	case xtree.Data:
+		return terminalValue(string(n.Value))
+	case xtree.CData:
+		return "CDATA " + terminalValue(string(n.Value))
+	case xtree.Comment:
+		return "comment " + terminalValue(string(n.Value))
+	case xtree.ProcInstr:
+		return "<?" + string(n.Name) + "?> " + terminalValue(string(n.Value))
+	case xtree.Doctype:
+		return "doctype " + terminalValue(string(n.Value))
+	case xtree.Declaration:
+		return "<?xml?>"
+	}
+	if len(n.Name) == 0 {
+		return n.Path()
+	}
+	return string(n.Name)
+}
+
+// terminalNote returns description of the node's change, with the location
+// of the node if it's known.
+func terminalNote(a annotation, loc string) string {
+	if loc != "" {
+		loc = ", " + loc
+	}
+	switch a.op {
+	case "insert":
+		return "(inserted at " + a.path + loc + ")"
+	case "delete":
+		return "(deleted from " + a.path + loc + ")"
+	case "update":
+		old, _ := annotationAttr([]annotation{a}, "old")
+		return "(updated from " + terminalValue(old) + " at " + a.path + loc + ")"
+	case "rename":
+		old, _ := annotationAttr([]annotation{a}, "old-name")
+		return "(renamed from " + old + " at " + a.path + loc + ")"
+	case "move":
+		from, _ := annotationAttr([]annotation{a}, "from")
+		return "(moved from " + from + " to " + a.path + loc + ")"
+	}
+	return "(" + a.op + "ed at " + a.path + loc + ")"
+}
+
+// terminalValue returns quoted value shortened to the maxTerminalValue
+// characters.
+func terminalValue(v string) string {
+	v = strings.TrimSpace(v)
+	if utf8.RuneCountInString(v) > maxTerminalValue {
+		v = string([]rune(v)[:maxTerminalValue-1]) + "…"
+	}
+	return strconv.Quote(v)
+}
@@ -0,0 +1,72 @@
+package xdiff
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ajankovic/xdiff/xtree"
+)
+
+func TestTerminalEncoder(t *testing.T) {
+	left := doc("", el("root", attr("id", "1"), el("a", dat("x < y")), el("b", el("c")), el("d"), el("g"), el("h"), el("i"), el("j")))
+	right := doc("", el("root", attr("id", "2"), el("a", dat("x > y")), el("b", el("c"), el("e")), el("g"), el("h"), el("i"), el("j", el("k", dat("z"))), el("f", attr("k", "v"))))
+	xtree.Prepare(left)
+	xtree.Prepare(right)
+	script, err := Compare(left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		deltas  []Delta
+		context int
+		color   bool
+		want    string
+	}{
+		{"No difference", nil, 1, false, "No difference.\n"},
+		{"Context", script, 1, false, `───┐/
+   └──┐<root>
+      ├───@id="2" (updated from "1" at /root[1]/@id)
+      ├──┐<a>
+      │  └───"x > y" (updated from "x < y" at /root[1]/a[1]/text()[1])
+      ├──┐<b>
+      │  ├───<c>
+      │  └───<e> (inserted at /root[1]/b[1]/e[1])
+      ├───<d> (deleted from /root[1]/d[1])
+      ├───<g>
+      ├───… 2 more
+      ├──┐<j>
+      │  └──┐<k> (inserted at /root[1]/j[1]/k[1])
+      │     └───"z"
+      └──┐<f> (inserted at /root[1]/f[1])
+         └───@k="v"
+`},
+		{"No context", script[:1], 0, false, `───┐/
+   └──┐<root>
+      ├───@id="2" (updated from "1" at /root[1]/@id)
+      └───… 7 more
+`},
+		{"Color", script[:1], 0, true, `───┐/
+   └──┐<root>
+      ├───` + colorYellow + `@id="2" (updated from "1" at /root[1]/@id)` + colorReset + `
+      └───… 7 more
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := NewTerminalEncoder(&buf, right)
+			if enc.Color {
+				t.Errorf("NewTerminalEncoder() enabled colors for %T", &buf)
+			}
+			enc.Context = tt.context
+			enc.Color = tt.color
+			if err := enc.Encode(tt.deltas); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Encode() =\n%s, want\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
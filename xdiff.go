@@ -25,14 +25,54 @@ const (
 	InsertSubtree
 	// DeleteSubtree operation.
 	DeleteSubtree
+	// Reorder node within its parent.
+	Reorder
+	// Move subtree to the different parent.
+	Move
+	// Rename element or attribute.
+	Rename
 )
 
 // Delta is a unit of change to the original doc that would change it into
 // edited document.
+//
+// Subject is the changed node of the left xtree, except for insertions where
+// it's the inserted node of the right xtree. Object is the counterpart of the
+// Subject in the right xtree, or the parent of the Subject for insertions and
+// deletions.
 type Delta struct {
 	Operation Operation
 	Subject   *xtree.Node
 	Object    *xtree.Node
+	// LeftParent is the node of the left xtree matched with the parent of the
+	// inserted or moved node in the right xtree. It's nil for the node moved
+	// into the inserted subtree.
+	LeftParent *xtree.Node
+	// RightParent is the node of the right xtree matched with the parent of
+	// the deleted or moved node in the left xtree.
+	RightParent *xtree.Node
+	// Left and Right address the change in the left and right xtree by
+	// paths, so the delta doesn't depend on the compared xtrees.
+	Left  NodeRef
+	Right NodeRef
+}
+
+// NodeRef refers to the node of the xtree by its path.
+//
+// Parent and Index tell where the node ends up in the xtree once the edit
+// script is applied to it, or the inverted script for the right xtree. Node
+// is detached copy of the node, whole subtree when it's inserted or deleted
+// and just the node itself otherwise.
+type NodeRef struct {
+	// Path of the node, empty if the node is not in the xtree.
+	Path string
+	// Parent is the path of the node's parent. Left Parent of the node
+	// moved into the inserted subtree is empty since the inserted subtree
+	// carries the node.
+	Parent string
+	// Index of the node among the parent's children.
+	Index int
+	Node  *xtree.Node
 }
 
 // nodePair just pairs up two nodes for easier reference.
@@ -41,59 +81,135 @@ type nodePair struct {
 	Right *xtree.Node
 }
 
-// minCostMatch is table of matched node pairs.
-type minCostMatch map[nodePair]struct{}
+// minCostMatch is table of matched node pairs. Pairs are indexed by both
+// left and right node so every lookup takes constant time.
+type minCostMatch struct {
+	leftToRight map[*xtree.Node]*xtree.Node
+	rightToLeft map[*xtree.Node]*xtree.Node
+}
 
-// Add idempotently adds new match to the given index.
-func (mcm minCostMatch) Add(match nodePair) minCostMatch {
-	_, ok := mcm[match]
-	if !ok && !mcm.HasLeft(match.Left) && !mcm.HasRight(match.Right) {
-		mcm[match] = struct{}{}
-		leftParent := match.Left.Parent
-		rightParent := match.Right.Parent
-		for leftParent != nil && rightParent != nil {
-			mcm[nodePair{leftParent, rightParent}] = struct{}{}
-			leftParent = leftParent.Parent
-			rightParent = rightParent.Parent
+// newMinCostMatch creates empty match table.
+func newMinCostMatch() *minCostMatch {
+	return &minCostMatch{
+		leftToRight: make(map[*xtree.Node]*xtree.Node),
+		rightToLeft: make(map[*xtree.Node]*xtree.Node),
+	}
+}
+
+// Add idempotently adds new match to the given index along with the
+// matches between their ancestors. Nodes that are already matched are
+// not matched again.
+func (mcm *minCostMatch) Add(match nodePair) *minCostMatch {
+	for match.Left != nil && match.Right != nil {
+		if mcm.HasLeft(match.Left) || mcm.HasRight(match.Right) {
+			break
 		}
+		mcm.leftToRight[match.Left] = match.Right
+		mcm.rightToLeft[match.Right] = match.Left
+		match = nodePair{match.Left.Parent, match.Right.Parent}
 	}
 	return mcm
 }
 
 // HasPair returns true if match table has pair matched.
-func (mcm minCostMatch) HasPair(match nodePair) bool {
-	_, ok := mcm[match]
-	return ok
+func (mcm *minCostMatch) HasPair(match nodePair) bool {
+	r, ok := mcm.leftToRight[match.Left]
+	return ok && r == match.Right
 }
 
 // HasLeft returns true if match table has the node in left position.
-func (mcm minCostMatch) HasLeft(n *xtree.Node) bool {
-	for p := range mcm {
-		if p.Left == n {
-			return true
-		}
-	}
-	return false
+func (mcm *minCostMatch) HasLeft(n *xtree.Node) bool {
+	_, ok := mcm.leftToRight[n]
+	return ok
 }
 
 // HasRight returns true if match table has the node in right position.
-func (mcm minCostMatch) HasRight(n *xtree.Node) bool {
-	for p := range mcm {
-		if p.Right == n {
-			return true
-		}
-	}
-	return false
+func (mcm *minCostMatch) HasRight(n *xtree.Node) bool {
+	_, ok := mcm.rightToLeft[n]
+	return ok
+}
+
+// Right returns node matched with the left node or nil if there is none.
+func (mcm *minCostMatch) Right(left *xtree.Node) *xtree.Node {
+	return mcm.leftToRight[left]
+}
+
+// Left returns node matched with the right node or nil if there is none.
+func (mcm *minCostMatch) Left(right *xtree.Node) *xtree.Node {
+	return mcm.rightToLeft[right]
+}
+
+// Pin adds the match without matching their ancestors.
+func (mcm *minCostMatch) Pin(match nodePair) *minCostMatch {
+	mcm.leftToRight[match.Left] = match.Right
+	mcm.rightToLeft[match.Right] = match.Left
+	return mcm
+}
+
+// Len returns number of matched pairs.
+func (mcm *minCostMatch) Len() int {
+	return len(mcm.leftToRight)
 }
 
-func (mcm minCostMatch) String() string {
+func (mcm *minCostMatch) String() string {
 	out := ""
-	for pair := range mcm {
-		out += fmt.Sprintf("%s\n", pair)
+	for l, r := range mcm.leftToRight {
+		out += fmt.Sprintf("%s\n", nodePair{l, r})
 	}
 	return out
 }
 
+// reducedTable holds identical pairs of nodes that are pinned before the
+// matching step along with the rest of the children of their parents.
+type reducedTable struct {
+	pinned   *minCostMatch
+	pairs    map[*xtree.Node][]nodePair
+	children map[*xtree.Node][]*xtree.Node
+}
+
+// newReducedTable creates empty reduced table.
+func newReducedTable() *reducedTable {
+	return &reducedTable{
+		pinned:   newMinCostMatch(),
+		pairs:    make(map[*xtree.Node][]nodePair),
+		children: make(map[*xtree.Node][]*xtree.Node),
+	}
+}
+
+// Pin adds identical pairs of children of the left and right node.
+func (rt *reducedTable) Pin(left, right *xtree.Node, pairs []nodePair) {
+	for _, pair := range pairs {
+		rt.pinned.Pin(pair)
+	}
+	rt.pairs[left] = pairs
+	for _, n := range []*xtree.Node{left, right} {
+		var children []*xtree.Node
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			if !rt.pinned.HasLeft(ch) && !rt.pinned.HasRight(ch) {
+				children = append(children, ch)
+			}
+		}
+		rt.children[n] = children
+	}
+}
+
+// Pairs returns pinned pairs of children of the nodes.
+func (rt *reducedTable) Pairs(left, right *xtree.Node) []nodePair {
+	pairs := rt.pairs[left]
+	if len(pairs) == 0 || pairs[0].Right.Parent != right {
+		return nil
+	}
+	return pairs
+}
+
+// Children returns children of the node which are not pinned.
+func (rt *reducedTable) Children(n *xtree.Node) []*xtree.Node {
+	if children, ok := rt.children[n]; ok {
+		return children
+	}
+	return n.Children()
+}
+
 // distTable holds editing distance from one pair to the other.
 type distTable map[nodePair]int
 
@@ -116,6 +232,32 @@ func (dt distTable) String() string {
 	return out
 }
 
+// sizeTable holds number of nodes in the subtrees.
+type sizeTable map[*xtree.Node]int
+
+// Of returns number of nodes in the subtree rooted at n.
+func (st sizeTable) Of(n *xtree.Node) int {
+	if size, ok := st[n]; ok {
+		return size
+	}
+	size := 1
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		size += st.Of(ch)
+	}
+	st[n] = size
+	return size
+}
+
+// Count sets the size of the subtree rooted at n without storing sizes of
+// its descendants.
+func (st sizeTable) Count(n *xtree.Node) {
+	size := 0
+	postOrder(n, nil, func(*xtree.Node) {
+		size++
+	})
+	st[n] = size
+}
+
 type costPair struct {
 	nodePair
 	Cost int
@@ -135,146 +277,343 @@ func (cp costPairs) Len() int {
 	return len(cp)
 }
 
+// Comparer holds configuration of the comparison between two xtrees.
+//
+// Zero value is not usable, use NewComparer to get comparer with default
+// options and then adjust the ones you need.
+type Comparer struct {
+	// Cost of inserting a node into the left xtree.
+	InsertCost int
+	// Cost of deleting a node from the left xtree.
+	DeleteCost int
+	// Cost of updating value of the leaf node.
+	UpdateCost int
+	// Cost of moving a node to the different position.
+	MoveCost int
+	// Greedy enables fast mode in which siblings are matched by picking
+	// the cheapest unused pair first instead of finding exact minimum-cost
+	// matching. It's faster but it can produce longer edit scripts.
+	Greedy bool
+	// Ordered makes order of the child nodes significant. Siblings are
+	// aligned so their relative order is kept and identical siblings that
+	// changed position are reported with Reorder operation. Attributes are
+	// always compared as unordered.
+	Ordered bool
+	// OrderPolicy decides for every parent node if order of its children is
+	// significant. When set it takes precedence over Ordered.
+	OrderPolicy OrderPolicy
+	// DetectMoves replaces deletion and insertion of identical nodes, or
+	// subtrees within the inserted ones, with the single Move operation.
+	DetectMoves bool
+	// Keys identifies sibling nodes by their keys, so nodes with equal keys
+	// are always matched and nodes with different keys never are.
+	Keys KeyPolicy
+	// DetectRenames replaces deletion and insertion of elements and
+	// attributes with the same parent and similar contents with the Rename
+	// operation.
+	DetectRenames bool
+	// RenameThreshold is the minimum similarity of the contents, between
+	// 0 and 1, for the nodes to be considered renamed. Value 1 requires
+	// identical contents.
+	RenameThreshold float64
+	// IgnorePrefixes drops changes of the namespace declarations from the
+	// edit script. Element and attribute names are compared by their
+	// namespaces and local names, so changes of the prefixes alone are
+	// then not reported.
+	IgnorePrefixes bool
+}
+
+// NewComparer instantiates new comparer with default options.
+func NewComparer() *Comparer {
+	return &Comparer{
+		InsertCost:      1,
+		DeleteCost:      1,
+		UpdateCost:      1,
+		MoveCost:        1,
+		RenameThreshold: 0.8,
+	}
+}
+
 // Compare generates slice of deltas that forms minimum-cost edit
-// script to transform the left xtree into the right xtree.
+// script to transform the left xtree into the right xtree using
+// default comparison options.
 func Compare(left *xtree.Node, right *xtree.Node) ([]Delta, error) {
+	return NewComparer().Compare(left, right)
+}
+
+// Compare generates slice of deltas that forms minimum-cost edit
+// script to transform the left xtree into the right xtree.
+func (c *Comparer) Compare(left *xtree.Node, right *xtree.Node) ([]Delta, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
 	if bytesEqual(left.Hash, right.Hash) {
 		return nil, nil
 	}
 
-	reduceMatchingSpace(left, right)
+	reduced := newReducedTable()
+	c.reduceMatchingSpace(left, right, reduced)
 
 	distTbl := make(distTable)
-	minCostM := make(minCostMatch)
-	minCostM.Add(nodePair{left, right})
-	var leftS, rightS xtree.Stack
-	var leftLastVisited, rightLastVisited *xtree.Node
-	var l, r = left, right
-	for !leftS.IsEmpty() || l != nil {
-		if l != nil {
-			leftS.Push(l)
-			l = l.FirstChild
-		} else {
-			leftPeek := leftS.Peek()
-			if leftPeek.NextSibling != nil && leftLastVisited != leftPeek.NextSibling {
-				l = leftPeek.NextSibling
-			} else {
-				r = right
-				for !rightS.IsEmpty() || r != nil {
-					if r != nil {
-						rightS.Push(r)
-						r = r.FirstChild
-					} else {
-						rightPeek := rightS.Peek()
-						if rightPeek.NextSibling != nil && rightLastVisited != rightPeek.NextSibling {
-							r = rightPeek.NextSibling
-						} else {
-							match(leftPeek, rightPeek, distTbl, minCostM)
-							rightLastVisited, _ = rightS.Pop()
-						}
-					}
-				}
-				leftLastVisited, _ = leftS.Pop()
-			}
-		}
+	sizes := make(sizeTable)
+	// Sizes of the pinned subtrees are counted upfront so the sizes of
+	// their descendants, which are never matched, don't fill the table.
+	for l, r := range reduced.pinned.leftToRight {
+		sizes.Count(l)
+		sizes.Count(r)
 	}
-	costBySig := make(map[string]costPairs)
-	for pair, cost := range distTbl {
-		if _, ok := costBySig[string(pair.Left.Signature)]; !ok {
-			costBySig[string(pair.Left.Signature)] = costPairs{costPair{pair, cost}}
-		} else {
-			costBySig[string(pair.Left.Signature)] = append(costBySig[string(pair.Left.Signature)], costPair{pair, cost})
+	// Only nodes with the same signature can be matched, so right nodes are
+	// grouped by signature to avoid visiting every pair of nodes. Both trees
+	// are visited in post-order so distances between children are known
+	// before their parents are matched. Subtrees of the reduced nodes are
+	// already matched so they are not visited.
+	rightBySig := make(map[string][]*xtree.Node)
+	postOrder(right, reduced.pinned.HasRight, func(r *xtree.Node) {
+		rightBySig[string(r.Signature)] = append(rightBySig[string(r.Signature)], r)
+	})
+	postOrder(left, reduced.pinned.HasLeft, func(l *xtree.Node) {
+		for _, r := range rightBySig[string(l.Signature)] {
+			c.match(l, r, distTbl, sizes, reduced)
 		}
+	})
+	minCostM := c.minCostMatching(left, right, distTbl, sizes, reduced)
+
+	script := c.editScript(left, right, minCostM)
+	if c.DetectRenames {
+		script = c.detectRenames(script, minCostM)
 	}
-	for _, costs := range costBySig {
-		if len(costs) > 1 {
-			sort.Sort(costs)
-		}
-		for _, cost := range costs {
-			minCostM.Add(cost.nodePair)
-		}
+	if c.DetectMoves {
+		script = detectMoves(script)
+	}
+	if c.IgnorePrefixes {
+		script = dropNamespaceDeclarations(script)
 	}
+	locate(script)
+	return script, nil
+}
 
-	return editScript(left, right, minCostM), nil
+// dropNamespaceDeclarations removes changes of the namespace declarations
+// from the edit script.
+func dropNamespaceDeclarations(script []Delta) []Delta {
+	kept := script[:0]
+	for _, d := range script {
+		if d.Subject.IsNamespaceDeclaration() || (d.Object != nil && d.Object.IsNamespaceDeclaration()) {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	return kept
 }
 
-// reduceMatchingSpace removes nodes with the same signature and hash value
-// to reduce number of comparisons for the matching step.
-// Every matching child is removed except one which is needed prerequisite for more
-// accurate matching between subtrees.
-func reduceMatchingSpace(left, right *xtree.Node) {
-	l := left.FirstChild
-	r := right.FirstChild
+// validate checks if comparer options are usable.
+func (c *Comparer) validate() error {
+	if c.InsertCost < 1 || c.DeleteCost < 1 || c.UpdateCost < 1 || c.MoveCost < 1 {
+		return fmt.Errorf("xdiff: operation costs must be positive, got insert %d, delete %d, update %d, move %d",
+			c.InsertCost, c.DeleteCost, c.UpdateCost, c.MoveCost)
+	}
+	if c.DetectRenames && (c.RenameThreshold <= 0 || c.RenameThreshold > 1) {
+		return fmt.Errorf("xdiff: rename threshold must be between 0 and 1, got %v", c.RenameThreshold)
+	}
+	return nil
+}
 
+// reduceMatchingSpace pins pairs of nodes with the same signature and hash
+// value to the reduced table, which excludes them from the matching step and
+// reduces number of comparisons. Every identical pair is pinned except one
+// which is needed prerequisite for more accurate matching between subtrees.
+func (c *Comparer) reduceMatchingSpace(left, right *xtree.Node, reduced *reducedTable) {
+	var lefts, rights []*xtree.Node
+	for l := left.FirstChild; l != nil; l = l.NextSibling {
+		if l.Type == xtree.Element {
+			lefts = append(lefts, l)
+		}
+	}
+	for r := right.FirstChild; r != nil; r = r.NextSibling {
+		if r.Type == xtree.Element {
+			rights = append(rights, r)
+		}
+	}
 	var candidates []nodePair
-	for l != nil && r != nil {
-		leftNext := l.NextSibling
-		rightNext := r.NextSibling
-		if (l.Type == xtree.Element && r.Type == xtree.Element) &&
-			bytesEqual(l.Signature, r.Signature) {
-			if bytesEqual(l.Hash, r.Hash) {
-				candidates = append(candidates, nodePair{l, r})
-			} else {
-				reduceMatchingSpace(l, r)
-			}
+	var restLeft, restRight []*xtree.Node
+	if c.isOrdered(left) {
+		// Only identical elements at both ends can be removed without
+		// losing the information about the order of siblings.
+		candidates, restLeft, restRight = commonEnds(lefts, rights)
+	} else {
+		candidates, restLeft, restRight = identicalPairs(lefts, rights)
+	}
+	// Rest of the elements are paired in order of appearance within
+	// the same signature and reduced further.
+	restBySig := make(map[string][]*xtree.Node)
+	for _, r := range restRight {
+		restBySig[string(r.Signature)] = append(restBySig[string(r.Signature)], r)
+	}
+	for _, l := range restLeft {
+		rs := restBySig[string(l.Signature)]
+		i := 0
+		for i < len(rs) && c.keysDiffer(l, rs[i]) {
+			i++
+		}
+		if i == len(rs) {
+			continue
 		}
-		l = leftNext
-		r = rightNext
+		c.reduceMatchingSpace(l, rs[i], reduced)
+		restBySig[string(l.Signature)] = append(rs[:i:i], rs[i+1:]...)
 	}
-	for i := 0; i < len(candidates)-1; i++ {
-		candidates[i].Left.Remove()
-		candidates[i].Right.Remove()
+	if len(candidates) > 1 {
+		reduced.Pin(left, right, candidates[:len(candidates)-1])
 	}
 }
 
-func match(l, r *xtree.Node, distTbl distTable, minCostM minCostMatch) {
-	if !bytesEqual(l.Signature, r.Signature) {
+// identicalPairs pairs nodes with the same hash value regardless of their
+// position and returns the nodes that were left unpaired.
+func identicalPairs(lefts, rights []*xtree.Node) ([]nodePair, []*xtree.Node, []*xtree.Node) {
+	identical := make(map[string][]*xtree.Node)
+	for _, r := range rights {
+		identical[string(r.Hash)] = append(identical[string(r.Hash)], r)
+	}
+	var pairs []nodePair
+	var restLeft, restRight []*xtree.Node
+	paired := make(map[*xtree.Node]struct{})
+	for _, l := range lefts {
+		rs := identical[string(l.Hash)]
+		if len(rs) > 0 && bytesEqual(l.Signature, rs[0].Signature) {
+			pairs = append(pairs, nodePair{l, rs[0]})
+			paired[rs[0]] = struct{}{}
+			identical[string(l.Hash)] = rs[1:]
+			continue
+		}
+		restLeft = append(restLeft, l)
+	}
+	for _, r := range rights {
+		if _, ok := paired[r]; !ok {
+			restRight = append(restRight, r)
+		}
+	}
+	return pairs, restLeft, restRight
+}
+
+// match calculates editing distance between the two nodes. Distances of all
+// the descendant pairs must already be in the distance table.
+func (c *Comparer) match(l, r *xtree.Node, distTbl distTable, sizes sizeTable, reduced *reducedTable) {
+	if !bytesEqual(l.Signature, r.Signature) || c.keysDiffer(l, r) {
 		return
 	}
 	pair := nodePair{l, r}
 	if bytesEqual(l.Hash, r.Hash) {
 		// Nodes match, no cost.
 		distTbl.Set(pair, 0)
-		minCostM.Add(pair)
 		return
 	}
 	if l.FirstChild == nil && r.FirstChild == nil {
 		// Set distance for Update.
-		distTbl.Set(pair, 1)
+		distTbl.Set(pair, c.UpdateCost)
 		return
 	} else if l.FirstChild == nil {
 		// Set distance for inserting all missing children into left.
-		distTbl.Set(pair, len(r.Children()))
+		distTbl.Set(pair, c.InsertCost*(sizes.Of(r)-1))
 		return
 	} else if r.FirstChild == nil {
 		// Set distance for deleting all children from left tree.
-		distTbl.Set(pair, len(l.Children()))
+		distTbl.Set(pair, c.DeleteCost*(sizes.Of(l)-1))
 		return
 	}
+	_, dist := c.matchChildren(l, r, distTbl, sizes, reduced)
+	distTbl.Set(pair, dist)
+}
+
+// minCostMatching generates minimum-cost matching between the xtrees by
+// going top down from the roots and matching children of every matched pair.
+func (c *Comparer) minCostMatching(left, right *xtree.Node, distTbl distTable, sizes sizeTable, reduced *reducedTable) *minCostMatch {
+	minCostM := newMinCostMatch()
+	minCostM.Add(nodePair{left, right})
+	queue := []nodePair{{left, right}}
+	for len(queue) > 0 {
+		pair := queue[0]
+		queue = queue[1:]
+		if bytesEqual(pair.Left.Hash, pair.Right.Hash) ||
+			pair.Left.FirstChild == nil || pair.Right.FirstChild == nil {
+			continue
+		}
+		matched, _ := c.matchChildren(pair.Left, pair.Right, distTbl, sizes, reduced)
+		for _, m := range matched {
+			minCostM.Add(m)
+		}
+		queue = append(queue, matched...)
+	}
+	return minCostM
+}
+
+// matchChildren finds minimum-cost matching between children of the nodes.
+// Only children with the same signature can be matched while unmatched
+// children cost as much as deleting or inserting their whole subtree.
+// Pinned children are matched only with their pinned counterparts.
+// It returns matched pairs along with the editing distance between the nodes.
+func (c *Comparer) matchChildren(l, r *xtree.Node, distTbl distTable, sizes sizeTable, reduced *reducedTable) ([]nodePair, int) {
+	matched := append([]nodePair{}, reduced.Pairs(l, r)...)
+	lefts, rights := reduced.Children(l), reduced.Children(r)
+	moved := 0
+	if c.isOrdered(l) {
+		var aligned []nodePair
+		aligned, moved = c.alignChildren(lefts, rights, distTbl, sizes)
+		matched = append(matched, aligned...)
+	} else {
+		matched = append(matched, c.matchUnordered(lefts, rights, distTbl, sizes)...)
+	}
+	// Start with the cost of deleting and inserting all the children and
+	// then replace it with the distance for every matched pair.
+	dist := c.DeleteCost*(sizes.Of(l)-1) + c.InsertCost*(sizes.Of(r)-1)
+	for _, pair := range matched {
+		dist += distTbl[pair] - c.DeleteCost*sizes.Of(pair.Left) - c.InsertCost*sizes.Of(pair.Right)
+	}
+	dist += c.MoveCost * moved
+	return matched, dist
+}
+
+// matchUnordered finds minimum-cost matching between the sibling nodes
+// regardless of their order.
+func (c *Comparer) matchUnordered(lefts, rights []*xtree.Node, distTbl distTable, sizes sizeTable) []nodePair {
 	// Group children of the non-leaf nodes by signature.
 	leftG := make(map[string][]*xtree.Node)
 	rightG := make(map[string][]*xtree.Node)
-	leftCount := 0
-	rightCount := 0
-	for ch := l.FirstChild; ch != nil; ch = ch.NextSibling {
-		leftCount++
+	for _, ch := range lefts {
 		leftG[string(ch.Signature)] = append(leftG[string(ch.Signature)], ch)
 	}
-	for ch := r.FirstChild; ch != nil; ch = ch.NextSibling {
-		rightCount++
+	for _, ch := range rights {
 		rightG[string(ch.Signature)] = append(rightG[string(ch.Signature)], ch)
 	}
 
-	var costs costPairs
-	dist := 0
+	var matched []nodePair
 	for sig, leftChildren := range leftG {
-		if rightChildren, ok := rightG[sig]; ok {
-			for _, leftCh := range leftChildren {
-				for _, rightCh := range rightChildren {
-					pair := nodePair{leftCh, rightCh}
-					c := distTbl[pair]
-					costs = append(costs, costPair{nodePair: pair, Cost: c})
-				}
+		rightChildren, ok := rightG[sig]
+		if !ok {
+			continue
+		}
+		keyed, leftChildren, rightChildren := c.keyedPairs(leftChildren, rightChildren)
+		matched = append(matched, keyed...)
+		if c.Greedy || len(leftChildren) > maxExactGroup || len(rightChildren) > maxExactGroup {
+			matched = append(matched, greedyMatch(leftChildren, rightChildren, distTbl)...)
+		} else {
+			matched = append(matched, c.exactMatch(leftChildren, rightChildren, distTbl, sizes)...)
+		}
+	}
+	return matched
+}
+
+// maxExactGroup limits the number of siblings with the same signature for
+// which exact matching is calculated. Larger groups are matched greedily
+// since cost of the exact matching grows cubically with the group size.
+const maxExactGroup = 300
+
+// greedyMatch matches the nodes by picking the cheapest pair of unused
+// nodes first.
+func greedyMatch(lefts, rights []*xtree.Node, distTbl distTable) []nodePair {
+	var costs costPairs
+	for _, l := range lefts {
+		for _, r := range rights {
+			pair := nodePair{l, r}
+			if cost, ok := distTbl[pair]; ok {
+				costs = append(costs, costPair{nodePair: pair, Cost: cost})
 			}
 		}
 	}
@@ -283,7 +622,7 @@ func match(l, r *xtree.Node, distTbl distTable, minCostM minCostMatch) {
 	}
 	usedLeft := make(map[*xtree.Node]struct{})
 	usedRight := make(map[*xtree.Node]struct{})
-	mapped := 0
+	var matched []nodePair
 	for _, cost := range costs {
 		if _, ok := usedLeft[cost.Left]; ok {
 			continue
@@ -291,63 +630,254 @@ func match(l, r *xtree.Node, distTbl distTable, minCostM minCostMatch) {
 		if _, ok := usedRight[cost.Right]; ok {
 			continue
 		}
-		dist += cost.Cost
-		mapped++
+		matched = append(matched, cost.nodePair)
 		usedLeft[cost.Left] = struct{}{}
 		usedRight[cost.Right] = struct{}{}
 	}
-	dist += leftCount + rightCount - 2*mapped
+	return matched
+}
 
-	distTbl.Set(pair, dist)
+// exactMatch finds matching between the nodes with the minimum total cost,
+// where leaving a node unmatched costs deletion or insertion of its subtree.
+// This is the minimum-cost maximum-flow matching described by the X-Diff paper.
+func (c *Comparer) exactMatch(lefts, rights []*xtree.Node, distTbl distTable, sizes sizeTable) []nodePair {
+	var matched []nodePair
+	// Identical subtrees are matched upfront, which shrinks the
+	// assignment problem without making the matching more expensive.
+	identical := make(map[string][]*xtree.Node)
+	for _, r := range rights {
+		identical[string(r.Hash)] = append(identical[string(r.Hash)], r)
+	}
+	used := make(map[*xtree.Node]struct{})
+	var restLeft, restRight []*xtree.Node
+	for _, l := range lefts {
+		candidates := identical[string(l.Hash)]
+		if len(candidates) == 0 {
+			restLeft = append(restLeft, l)
+			continue
+		}
+		matched = append(matched, nodePair{l, candidates[0]})
+		used[candidates[0]] = struct{}{}
+		identical[string(l.Hash)] = candidates[1:]
+	}
+	for _, r := range rights {
+		if _, ok := used[r]; !ok {
+			restRight = append(restRight, r)
+		}
+	}
+	if len(restLeft) == 0 || len(restRight) == 0 {
+		return matched
+	}
+
+	// Matching the pair pays off only if it's cheaper than deleting the left
+	// and inserting the right subtree, so the cost is the difference of the two
+	// which is never positive. Zero cost pairs are left unmatched.
+	cost := make([][]int, len(restLeft))
+	for i, l := range restLeft {
+		cost[i] = make([]int, len(restRight))
+		for j, r := range restRight {
+			dist, ok := distTbl[nodePair{l, r}]
+			if !ok {
+				continue
+			}
+			unmatched := c.DeleteCost*sizes.Of(l) + c.InsertCost*sizes.Of(r)
+			if d := dist - unmatched; d < 0 {
+				cost[i][j] = d
+			}
+		}
+	}
+	for i, j := range minCostAssignment(cost) {
+		if j >= 0 && cost[i][j] < 0 {
+			matched = append(matched, nodePair{restLeft[i], restRight[j]})
+		}
+	}
+	return matched
 }
 
 // editScript generates slice of deltas that forms minimum-cost edit script to transform
 // left xtree into a right xtree.
 // TODO change algorithm to the iterative traversal
-func editScript(left, right *xtree.Node, minCostM minCostMatch) []Delta {
+func (c *Comparer) editScript(left, right *xtree.Node, minCostM *minCostMatch) []Delta {
 	var script []Delta
-	rootPair := nodePair{left, right}
-	_, ok := minCostM[rootPair]
-	if !ok {
+	if !minCostM.HasPair(nodePair{left, right}) {
 		return []Delta{
-			Delta{Operation: DeleteSubtree, Subject: left, Object: left.Parent},
-			Delta{Operation: InsertSubtree, Subject: right, Object: left.Parent},
+			{Operation: DeleteSubtree, Subject: left, Object: left.Parent, RightParent: right.Parent},
+			{Operation: InsertSubtree, Subject: right, Object: right.Parent, LeftParent: left.Parent},
 		}
 	}
+	var moved map[*xtree.Node]struct{}
+	if c.isOrdered(left) {
+		moved = reordered(left, right, minCostM)
+	}
 	for l := left.FirstChild; l != nil; l = l.NextSibling {
-		for r := right.FirstChild; r != nil; r = r.NextSibling {
-			pair := nodePair{l, r}
-			if _, ok := minCostM[pair]; ok {
-				if l.FirstChild == nil && r.FirstChild == nil {
-					if bytesEqual(l.Hash, r.Hash) {
-						continue
-					}
-					script = append(script, Delta{Operation: Update, Subject: l, Object: r})
-					continue
-				}
-				script = append(script, editScript(l, r, minCostM)...)
+		r := minCostM.Right(l)
+		if r == nil {
+			if l.FirstChild == nil {
+				script = append(script, Delta{Operation: Delete, Subject: l, Object: l.Parent, RightParent: right})
+				continue
 			}
+			script = append(script, Delta{Operation: DeleteSubtree, Subject: l, Object: l.Parent, RightParent: right})
+			continue
 		}
-		if !minCostM.HasLeft(l) {
-			if l.FirstChild == nil {
-				script = append(script, Delta{Operation: Delete, Subject: l, Object: l.Parent})
+		if _, ok := moved[l]; ok {
+			script = append(script, Delta{Operation: Reorder, Subject: l, Object: r})
+		}
+		if l.FirstChild == nil && r.FirstChild == nil {
+			if bytesEqual(l.Hash, r.Hash) {
 				continue
 			}
-			script = append(script, Delta{Operation: DeleteSubtree, Subject: l, Object: l.Parent})
+			script = append(script, Delta{Operation: Update, Subject: l, Object: r})
+			continue
 		}
+		if bytesEqual(l.Hash, r.Hash) {
+			continue
+		}
+		script = append(script, c.editScript(l, r, minCostM)...)
 	}
 	for r := right.FirstChild; r != nil; r = r.NextSibling {
 		if !minCostM.HasRight(r) {
 			if r.FirstChild == nil {
-				script = append(script, Delta{Operation: Insert, Subject: r, Object: r.Parent})
+				script = append(script, Delta{Operation: Insert, Subject: r, Object: r.Parent, LeftParent: left})
 				continue
 			}
-			script = append(script, Delta{Operation: InsertSubtree, Subject: r, Object: r.Parent})
+			script = append(script, Delta{Operation: InsertSubtree, Subject: r, Object: r.Parent, LeftParent: left})
 		}
 	}
 	return script
 }
 
+// detectMoves pairs deleted and inserted nodes with the same hash value
+// and replaces them with moves of the deleted nodes to the place of the
+// inserted ones. Deleted subtrees without an identical inserted one are
+// moved into the inserted subtrees containing identical subtree. Such move
+// has no LeftParent since the inserted subtree carries the moved node, so
+// applying it only removes the node from its old place.
+func detectMoves(script []Delta) []Delta {
+	inserted := make(map[string][]int)
+	nested := make(map[string][]*xtree.Node)
+	for i, d := range script {
+		switch d.Operation {
+		case Insert:
+			inserted[string(d.Subject.Hash)] = append(inserted[string(d.Subject.Hash)], i)
+		case InsertSubtree:
+			inserted[string(d.Subject.Hash)] = append(inserted[string(d.Subject.Hash)], i)
+			for s := d.Subject.Children(); len(s) > 0; {
+				n := s[len(s)-1]
+				s = append(s[:len(s)-1], n.Children()...)
+				if n.FirstChild != nil {
+					nested[string(n.Hash)] = append(nested[string(n.Hash)], n)
+				}
+			}
+		}
+	}
+	if len(inserted) == 0 {
+		return script
+	}
+	// Nodes are moved to the inserted nodes, and to the nodes within them
+	// which are not already replaced by the moves.
+	targets := make(map[*xtree.Node]struct{})
+	covered := make(map[*xtree.Node]struct{})
+	free := func(n *xtree.Node) bool {
+		if _, ok := covered[n]; ok {
+			return false
+		}
+		for p := n.Parent; p != nil; p = p.Parent {
+			if _, ok := targets[p]; ok {
+				return false
+			}
+		}
+		return true
+	}
+	use := func(n *xtree.Node) {
+		targets[n] = struct{}{}
+		for ; n != nil; n = n.Parent {
+			covered[n] = struct{}{}
+		}
+	}
+	moved := make(map[int]struct{})
+	var unmoved []int
+	for i, d := range script {
+		if d.Operation != Delete && d.Operation != DeleteSubtree {
+			continue
+		}
+		candidates := inserted[string(d.Subject.Hash)]
+		for len(candidates) > 0 && !free(script[candidates[0]].Subject) {
+			candidates = candidates[1:]
+		}
+		inserted[string(d.Subject.Hash)] = candidates
+		if len(candidates) == 0 {
+			unmoved = append(unmoved, i)
+			continue
+		}
+		insert := script[candidates[0]]
+		script[i] = Delta{Operation: Move, Subject: d.Subject, Object: insert.Subject,
+			LeftParent: insert.LeftParent, RightParent: d.RightParent}
+		moved[candidates[0]] = struct{}{}
+		inserted[string(d.Subject.Hash)] = candidates[1:]
+		use(insert.Subject)
+	}
+	for _, i := range unmoved {
+		d := script[i]
+		if d.Operation != DeleteSubtree {
+			continue
+		}
+		candidates := nested[string(d.Subject.Hash)]
+		for len(candidates) > 0 && !free(candidates[0]) {
+			candidates = candidates[1:]
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		script[i] = Delta{Operation: Move, Subject: d.Subject, Object: candidates[0],
+			RightParent: d.RightParent}
+		nested[string(d.Subject.Hash)] = candidates[1:]
+		use(candidates[0])
+	}
+	result := script[:0]
+	for i, d := range script {
+		if _, ok := moved[i]; !ok {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// postOrder visits every node of the xtree rooted at n in post-order.
+// Subtrees of the nodes for which skip returns true are not visited.
+func postOrder(n *xtree.Node, skip func(*xtree.Node) bool, visit func(*xtree.Node)) {
+	if skip != nil && skip(n) {
+		return
+	}
+	// firstLeaf descends to the first node without children to visit.
+	firstLeaf := func(n *xtree.Node) *xtree.Node {
+		for ch := nextUnskipped(n.FirstChild, skip); ch != nil; ch = nextUnskipped(n.FirstChild, skip) {
+			n = ch
+		}
+		return n
+	}
+	root := n
+	for n = firstLeaf(root); ; {
+		visit(n)
+		if n == root {
+			return
+		}
+		if next := nextUnskipped(n.NextSibling, skip); next != nil {
+			n = firstLeaf(next)
+		} else {
+			n = n.Parent
+		}
+	}
+}
+
+// nextUnskipped returns the first of the node and its next siblings for
+// which skip doesn't return true.
+func nextUnskipped(n *xtree.Node, skip func(*xtree.Node) bool) *xtree.Node {
+	for skip != nil && n != nil && skip(n) {
+		n = n.NextSibling
+	}
+	return n
+}
+
 func contains(n *xtree.Node, nodes []*xtree.Node) bool {
 	for _, x := range nodes {
 		if x == n {
@@ -1,6 +1,9 @@
 package xdiff
 
 import (
+	"bytes"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/ajankovic/xdiff/xtree"
@@ -234,24 +237,23 @@ func TestReducingMatchingSpace(t *testing.T) {
 					dat("value3")))))
 	xtree.Prepare(left)
 	xtree.Prepare(right)
-	reduceMatchingSpace(left, right)
+	reduced := newReducedTable()
+	NewComparer().reduceMatchingSpace(left, right, reduced)
 	l, _ := xtree.TextString(left)
 	r, _ := xtree.TextString(right)
 
-	if len(left.FirstChild.Children()) != 2 {
+	if len(left.FirstChild.Children()) != 3 || len(right.FirstChild.Children()) != 3 {
 		t.Logf("\n%s", l)
 		t.Logf("\n%s", r)
-		t.Error("root doesn't have two child nodes")
+		t.Error("reducing changed the xtrees")
 	}
-	if len(left.FirstChild.FirstChild.Children()) != 2 {
-		t.Logf("\n%s", l)
-		t.Logf("\n%s", r)
-		t.Error("element doesn't have two child nodes")
+	if reduced.pinned.Len() != 1 {
+		t.Logf("\n%s", reduced.pinned)
+		t.Fatalf("reduced %d pairs, want 1", reduced.pinned.Len())
 	}
-	if len(left.FirstChild.FirstChild.FirstChild.NextSibling.Children()) != 1 {
-		t.Logf("\n%s", l)
-		t.Logf("\n%s", r)
-		t.Error("subelement doesn't have one child node")
+	if !reduced.pinned.HasPair(nodePair{left.FirstChild.FirstChild, right.FirstChild.FirstChild}) {
+		t.Logf("\n%s", reduced.pinned)
+		t.Error("first elements are not reduced")
 	}
 }
 
@@ -301,3 +303,826 @@ func decl(children ...*xtree.Node) *xtree.Node {
 func proc(name, value string) *xtree.Node {
 	return xtree.NewProcInstr([]byte(name), []byte(value))
 }
+
+func TestComparerOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *Comparer
+		wantErr bool
+	}{
+		{"Default options", NewComparer(), false},
+		{"Zero value", &Comparer{}, true},
+		{"Negative cost", &Comparer{InsertCost: 1, DeleteCost: -1, UpdateCost: 1, MoveCost: 1}, true},
+		{"Custom costs", &Comparer{InsertCost: 2, DeleteCost: 2, UpdateCost: 3, MoveCost: 2}, false},
+		{"Invalid rename threshold", &Comparer{InsertCost: 1, DeleteCost: 1, UpdateCost: 1, MoveCost: 1,
+			DetectRenames: true, RenameThreshold: 1.5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left := doc("", el("root", dat("value")))
+			right := doc("", el("root", dat("edited")))
+			xtree.Prepare(left)
+			xtree.Prepare(right)
+			got, err := tt.c.Compare(left, right)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Comparer.Compare() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !operationIn(Update, got) {
+				t.Errorf("Comparer.Compare() = %v, want Update", got)
+			}
+		})
+	}
+}
+
+func TestExactMatchingShorterThanGreedy(t *testing.T) {
+	item := func(values ...string) *xtree.Node {
+		n := el("item")
+		for i, v := range values {
+			n.AppendChild(attr(fmt.Sprintf("p%d", i), v))
+		}
+		return n
+	}
+	tests := []struct {
+		name       string
+		left       func() *xtree.Node
+		right      func() *xtree.Node
+		wantExact  int
+		wantGreedy int
+	}{
+		{
+			"Cheapest pair is not part of the minimum-cost matching",
+			func() *xtree.Node {
+				return doc("",
+					el("root",
+						item("0", "0", "0", "0", "0"),
+						item("1", "0", "0", "3", "3")))
+			},
+			func() *xtree.Node {
+				return doc("",
+					el("root",
+						item("1", "0", "0", "0", "0"),
+						item("0", "2", "2", "0", "0")))
+			},
+			4,
+			6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func(greedy bool) []Delta {
+				left, right := tt.left(), tt.right()
+				xtree.Prepare(left)
+				xtree.Prepare(right)
+				c := NewComparer()
+				c.Greedy = greedy
+				got, err := c.Compare(left, right)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return got
+			}
+			if got := run(false); len(got) != tt.wantExact {
+				t.Errorf("exact Compare() =\n%v, want %d deltas", got, tt.wantExact)
+			}
+			if got := run(true); len(got) != tt.wantGreedy {
+				t.Errorf("greedy Compare() =\n%v, want %d deltas", got, tt.wantGreedy)
+			}
+		})
+	}
+}
+
+func TestMinCostAssignment(t *testing.T) {
+	tests := []struct {
+		name string
+		cost [][]int
+		want int
+	}{
+		{"Single cell", [][]int{{7}}, 7},
+		{"Square", [][]int{{4, 1, 3}, {2, 0, 5}, {3, 2, 2}}, 5},
+		{"Greedy trap", [][]int{{1, 2}, {2, 5}}, 4},
+		{"More columns", [][]int{{5, 1, 9}, {1, 5, 9}}, 2},
+		{"More rows", [][]int{{5, 1}, {1, 5}, {0, 0}}, 1},
+		{"Negative costs", [][]int{{-3, -1}, {-2, 0}}, -3},
+		{"Costs above MaxInt32", [][]int{{1 << 32, 2 << 32, 3 << 32}, {2 << 32, 5 << 32, 1 << 32}, {3 << 32, 1 << 32, 4 << 32}}, 3 << 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := minCostAssignment(tt.cost)
+			used := make(map[int]bool)
+			total := 0
+			for i, j := range rows {
+				if j < 0 {
+					continue
+				}
+				if used[j] {
+					t.Fatalf("minCostAssignment() = %v, column %d assigned twice", rows, j)
+				}
+				used[j] = true
+				total += tt.cost[i][j]
+			}
+			if total != tt.want {
+				t.Errorf("minCostAssignment() = %v with cost %d, want cost %d", rows, total, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkCompare(b *testing.B) {
+	for _, size := range []int{10000, 100000, 1000000} {
+		b.Run(fmt.Sprintf("nodes=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				left := generateTree(size, false)
+				right := generateTree(size, true)
+				b.StartTimer()
+				if _, err := Compare(left, right); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// scanMatch is the match table indexed only by the pairs, which has to be
+// scanned to find whether a node is matched. It's the baseline for
+// minCostMatch in BenchmarkMatchLookup.
+type scanMatch map[nodePair]struct{}
+
+// HasLeft returns true if match table has the node in left position.
+func (sm scanMatch) HasLeft(n *xtree.Node) bool {
+	for p := range sm {
+		if p.Left == n {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRight returns true if match table has the node in right position.
+func (sm scanMatch) HasRight(n *xtree.Node) bool {
+	for p := range sm {
+		if p.Right == n {
+			return true
+		}
+	}
+	return false
+}
+
+func BenchmarkMatchLookup(b *testing.B) {
+	for _, size := range []int{1000, 10000} {
+		var lefts, rights []*xtree.Node
+		postOrder(generateTree(size, false), nil, func(n *xtree.Node) { lefts = append(lefts, n) })
+		postOrder(generateTree(size, true), nil, func(n *xtree.Node) { rights = append(rights, n) })
+		scan := make(scanMatch)
+		indexed := newMinCostMatch()
+		for i := 0; i < len(lefts) && i < len(rights); i++ {
+			scan[nodePair{lefts[i], rights[i]}] = struct{}{}
+			indexed.leftToRight[lefts[i]] = rights[i]
+			indexed.rightToLeft[rights[i]] = lefts[i]
+		}
+		b.Run(fmt.Sprintf("scan/nodes=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := range lefts {
+					scan.HasLeft(lefts[j])
+				}
+				for j := range rights {
+					scan.HasRight(rights[j])
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("indexed/nodes=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := range lefts {
+					indexed.HasLeft(lefts[j])
+				}
+				for j := range rights {
+					indexed.HasRight(rights[j])
+				}
+			}
+		})
+	}
+}
+
+// generateTree builds prepared document with approximately size nodes made
+// of sections with records. Edited tree has some of the records updated,
+// deleted or extended with additional fields.
+func generateTree(size int, edited bool) *xtree.Node {
+	const recordSize = 9
+	const sectionSize = 100
+	root := el("root")
+	var section *xtree.Node
+	for i := 0; i < size/recordSize; i++ {
+		if i%sectionSize == 0 {
+			section = el("section", attr("id", fmt.Sprint(i/sectionSize)))
+			root.AppendChild(section)
+		}
+		if edited && i%1500 == 7 {
+			continue
+		}
+		value := fmt.Sprintf("value %d", i)
+		if edited && i%1000 == 3 {
+			value = fmt.Sprintf("edited %d", i)
+		}
+		record := el("record",
+			attr("id", fmt.Sprint(i)),
+			attr("type", fmt.Sprint(i%7)),
+			el("name", dat(fmt.Sprintf("record %d", i))),
+			el("value", dat(value)),
+			el("note", dat("note")))
+		if edited && i%2000 == 11 {
+			record.AppendChild(el("extra", dat("extra")))
+		}
+		section.AppendChild(record)
+	}
+	n := doc("", root)
+	xtree.Prepare(n)
+	return n
+}
+
+func TestMinCostMatch(t *testing.T) {
+	left := doc("", el("root", el("a"), el("b")))
+	right := doc("", el("root", el("a"), el("b")))
+	la, lb := left.FirstChild.FirstChild, left.FirstChild.FirstChild.NextSibling
+	ra, rb := right.FirstChild.FirstChild, right.FirstChild.FirstChild.NextSibling
+
+	m := newMinCostMatch()
+	m.Add(nodePair{la, ra})
+	if !m.HasPair(nodePair{left, right}) || !m.HasPair(nodePair{left.FirstChild, right.FirstChild}) {
+		t.Errorf("Add() didn't match ancestors\n%s", m)
+	}
+	m.Add(nodePair{lb, ra})
+	if m.HasPair(nodePair{lb, ra}) || m.HasLeft(lb) {
+		t.Errorf("Add() matched already matched node\n%s", m)
+	}
+	m.Add(nodePair{lb, rb})
+	if m.Right(lb) != rb || m.Left(rb) != lb {
+		t.Errorf("Right() = %v, Left() = %v, want matched b nodes", m.Right(lb), m.Left(rb))
+	}
+	if m.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", m.Len())
+	}
+}
+
+func TestCompareOrdered(t *testing.T) {
+	tests := []struct {
+		name      string
+		left      *xtree.Node
+		right     *xtree.Node
+		ordered   []Operation
+		unordered []Operation
+	}{
+		{
+			"Reordered siblings",
+			doc("",
+				el("root",
+					el("a", dat("1")),
+					el("b", dat("2")),
+					el("c", dat("3")))),
+			doc("",
+				el("root",
+					el("c", dat("3")),
+					el("a", dat("1")),
+					el("b", dat("2")))),
+			[]Operation{Reorder},
+			nil,
+		},
+		{
+			"Swapped siblings with the same signature",
+			doc("",
+				el("root",
+					el("p", dat("first")),
+					el("p", dat("second")))),
+			doc("",
+				el("root",
+					el("p", dat("second")),
+					el("p", dat("first")))),
+			[]Operation{Reorder},
+			nil,
+		},
+		{
+			"Reordered and changed siblings",
+			doc("",
+				el("root",
+					el("a", dat("1")),
+					el("b", dat("2")))),
+			doc("",
+				el("root",
+					el("b", dat("3")),
+					el("a", dat("4")))),
+			[]Operation{Reorder, Update, Update},
+			[]Operation{Update, Update},
+		},
+		{
+			"Reordered attributes",
+			doc("",
+				el("root",
+					attr("a", "1"),
+					attr("b", "2"))),
+			doc("",
+				el("root",
+					attr("b", "2"),
+					attr("a", "1"))),
+			nil,
+			nil,
+		},
+		{
+			"Mixed content",
+			doc("",
+				el("p",
+					dat("Hello "),
+					el("b", dat("world")),
+					dat(" again"))),
+			doc("",
+				el("p",
+					dat("Hello "),
+					el("b", dat("world")),
+					dat(" and again"))),
+			[]Operation{Update},
+			[]Operation{Update},
+		},
+		{
+			"Inserted sibling",
+			doc("",
+				el("root",
+					el("p", dat("one")),
+					el("p", dat("two")))),
+			doc("",
+				el("root",
+					el("p", dat("one")),
+					el("p", dat("new")),
+					el("p", dat("two")))),
+			[]Operation{InsertSubtree},
+			[]Operation{InsertSubtree},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xtree.Prepare(tt.left)
+			xtree.Prepare(tt.right)
+			l, _ := xtree.TextString(tt.left)
+			r, _ := xtree.TextString(tt.right)
+			for _, ordered := range []bool{true, false} {
+				want := tt.unordered
+				if ordered {
+					want = tt.ordered
+				}
+				c := NewComparer()
+				c.Ordered = ordered
+				got, err := c.Compare(tt.left, tt.right)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got) != len(want) {
+					t.Logf("\n%s", l)
+					t.Logf("\n%s", r)
+					t.Fatalf("Compare() ordered %v =\n%v, want\n%v", ordered, got, want)
+				}
+				for i := range want {
+					if got[i].Operation != want[i] {
+						t.Errorf("Compare() ordered %v =\n%v, want\n%v", ordered, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLongestIncreasing(t *testing.T) {
+	tests := []struct {
+		values []int
+		want   int
+	}{
+		{nil, 0},
+		{[]int{0, 1, 2}, 3},
+		{[]int{2, 0, 1}, 2},
+		{[]int{3, 1, 2, 0, 4}, 3},
+		{[]int{4, 3, 2, 1}, 1},
+	}
+	for _, tt := range tests {
+		got := longestIncreasing(tt.values)
+		if len(got) != tt.want {
+			t.Errorf("longestIncreasing(%v) = %v, want length %d", tt.values, got, tt.want)
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i-1] >= got[i] || tt.values[got[i-1]] >= tt.values[got[i]] {
+				t.Errorf("longestIncreasing(%v) = %v is not increasing", tt.values, got)
+			}
+		}
+	}
+}
+
+func TestCompareOrderPolicy(t *testing.T) {
+	newTree := func(first, second string) *xtree.Node {
+		n := doc("",
+			el("project",
+				el("modules",
+					el("module", dat(first)),
+					el("module", dat(second))),
+				el("goals",
+					el("goal", dat(first)),
+					el("goal", dat(second)))))
+		xtree.Prepare(n)
+		return n
+	}
+	tests := []struct {
+		name   string
+		policy func() OrderPolicy
+		want   int
+	}{
+		{"Unordered by default", func() OrderPolicy { return NewSignaturePolicy() }, 0},
+		{"Ordered path", func() OrderPolicy { return NewSignaturePolicy("/project/goals") }, 1},
+		{"Ordered signature", func() OrderPolicy { return NewSignaturePolicy("/project/goals/Element") }, 1},
+		{"Unordered path", func() OrderPolicy {
+			sp := NewSignaturePolicy()
+			sp.Default = true
+			sp.Set("/project/goals", false)
+			return sp
+		}, 1},
+		{"Ordered by default", func() OrderPolicy {
+			sp := NewSignaturePolicy()
+			sp.Default = true
+			return sp
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewComparer()
+			c.OrderPolicy = tt.policy()
+			got, err := c.Compare(newTree("a", "b"), newTree("b", "a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Fatalf("Compare() =\n%v, want %d deltas", got, tt.want)
+			}
+			for _, d := range got {
+				if d.Operation != Reorder {
+					t.Errorf("Compare() =\n%v, want only Reorder", got)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareMoves(t *testing.T) {
+	newTree := func(moved bool) *xtree.Node {
+		item := el("item", attr("id", "1"), el("name", dat("first")), el("price", dat("10")))
+		from, to := el("from", item), el("to")
+		if moved {
+			from, to = el("from"), el("to", item)
+		}
+		n := doc("", el("root", from, to))
+		xtree.Prepare(n)
+		return n
+	}
+	tests := []struct {
+		name        string
+		detectMoves bool
+		want        []Operation
+	}{
+		{"Moves detected", true, []Operation{Move}},
+		{"Moves not detected", false, []Operation{DeleteSubtree, InsertSubtree}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewComparer()
+			c.DetectMoves = tt.detectMoves
+			got, err := c.Compare(newTree(false), newTree(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Compare() =\n%v, want %v", got, tt.want)
+			}
+			for i, d := range got {
+				if d.Operation != tt.want[i] {
+					t.Errorf("Compare() =\n%v, want %v", got, tt.want)
+				}
+			}
+			if tt.detectMoves {
+				if string(got[0].Subject.Parent.Name) != "from" ||
+					string(got[0].Object.Parent.Name) != "to" {
+					t.Errorf("Move(%v -> %v), want move from 'from' to 'to'", got[0].Subject, got[0].Object)
+				}
+			}
+		})
+	}
+	// Compare keeps reporting moved subtrees as deletions and insertions.
+	got, err := Compare(newTree(false), newTree(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Operation != DeleteSubtree || got[1].Operation != InsertSubtree {
+		t.Errorf("Compare() =\n%v, want [DeleteSubtree InsertSubtree]", got)
+	}
+}
+
+func TestCompareMovedNodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		left  func() *xtree.Node
+		right func() *xtree.Node
+		want  []Operation
+	}{
+		{
+			"Moved text",
+			func() *xtree.Node { return doc("", el("root", el("a", dat("text")), el("b", attr("id", "1")))) },
+			func() *xtree.Node { return doc("", el("root", el("a"), el("b", attr("id", "1"), dat("text")))) },
+			[]Operation{Move},
+		},
+		{
+			"Moved attribute",
+			func() *xtree.Node { return doc("", el("root", el("a", attr("id", "1"), dat("a")), el("b", dat("b")))) },
+			func() *xtree.Node { return doc("", el("root", el("a", dat("a")), el("b", attr("id", "1"), dat("b")))) },
+			[]Operation{Move},
+		},
+		{
+			"Moved into inserted wrapper",
+			func() *xtree.Node {
+				return doc("", el("root", el("c", el("d", dat("1")), el("e", dat("2"))), el("f")))
+			},
+			func() *xtree.Node {
+				return doc("", el("root", el("f"), el("g", attr("id", "1"), el("c", el("d", dat("1")), el("e", dat("2"))))))
+			},
+			[]Operation{Move, InsertSubtree},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, right := tt.left(), tt.right()
+			xtree.Prepare(left)
+			xtree.Prepare(right)
+			c := NewComparer()
+			c.DetectMoves = true
+			got, err := c.Compare(left, right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Compare() =\n%v, want %v", got, tt.want)
+			}
+			for i, d := range got {
+				if d.Operation != tt.want[i] {
+					t.Errorf("Compare() =\n%v, want %v", got, tt.want)
+				}
+			}
+			for name, apply := range map[string]func() (*xtree.Node, *xtree.Node, error){
+				"Apply": func() (*xtree.Node, *xtree.Node, error) {
+					l, r := tt.left(), tt.right()
+					xtree.Prepare(r)
+					return l, r, Apply(l, got)
+				},
+				"Apply inverted": func() (*xtree.Node, *xtree.Node, error) {
+					l, r := tt.left(), tt.right()
+					xtree.Prepare(l)
+					return r, l, Apply(r, Invert(got))
+				},
+			} {
+				changed, want, err := apply()
+				if err != nil {
+					t.Fatalf("%s() error %v", name, err)
+				}
+				if !bytes.Equal(changed.Hash, want.Hash) {
+					c, _ := xtree.TextString(changed)
+					w, _ := xtree.TextString(want)
+					t.Errorf("%s() =\n%s, want\n%s", name, c, w)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareKeys(t *testing.T) {
+	dependency := func(artifactID, version string, scope bool) *xtree.Node {
+		n := el("dependency", el("artifactId", dat(artifactID)), el("version", dat(version)))
+		if scope {
+			n.AppendChild(el("scope", dat("test")))
+		}
+		return n
+	}
+	item := func(id, name string) *xtree.Node {
+		return el("item", attr("id", id), el("name", dat(name)))
+	}
+	tests := []struct {
+		name  string
+		left  *xtree.Node
+		right *xtree.Node
+		keys  func() KeyPolicy
+		want  int
+	}{
+		{"Without keys",
+			doc("", el("project", dependency("a", "1", true), dependency("b", "2", false))),
+			doc("", el("project", dependency("a", "2", false), dependency("b", "1", true))),
+			func() KeyPolicy { return nil }, 2},
+		{"Equal child element keys",
+			doc("", el("project", dependency("a", "1", true), dependency("b", "2", false))),
+			doc("", el("project", dependency("a", "2", false), dependency("b", "1", true))),
+			func() KeyPolicy {
+				sk := NewSignatureKeys()
+				sk.Set("/project/dependency", "artifactId")
+				return sk
+			}, 3},
+		{"Different attribute keys",
+			doc("", el("items", item("1", "x"))),
+			doc("", el("items", item("2", "x"))),
+			func() KeyPolicy {
+				sk := NewSignatureKeys()
+				sk.Set("/items/item/Element", "@id")
+				return sk
+			}, 2},
+		{"Equal attribute keys",
+			doc("", el("items", item("1", "x"), item("2", "y"))),
+			doc("", el("items", item("1", "y"), item("2", "x"))),
+			func() KeyPolicy {
+				sk := NewSignatureKeys()
+				sk.Set("/items/item", "@id")
+				return sk
+			}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xtree.Prepare(tt.left)
+			xtree.Prepare(tt.right)
+			c := NewComparer()
+			c.DetectMoves = true
+			c.Keys = tt.keys()
+			got, err := c.Compare(tt.left, tt.right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Fatalf("Compare() =\n%v, want %d deltas", got, tt.want)
+			}
+			if tt.keys() == nil {
+				return
+			}
+			for _, d := range got {
+				if d.Operation == Update &&
+					(string(d.Subject.Parent.Name) == "artifactId" || string(d.Subject.Name) == "id") {
+					t.Errorf("Compare() =\n%v, want keys unchanged", got)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareRenames(t *testing.T) {
+	tests := []struct {
+		name      string
+		left      *xtree.Node
+		right     *xtree.Node
+		threshold float64
+		want      []Operation
+	}{
+		{"Renamed element",
+			doc("", el("root", el("customer", el("name", dat("John")), el("city", dat("Oslo"))))),
+			doc("", el("root", el("client", el("name", dat("John")), el("city", dat("Oslo"))))),
+			1, []Operation{Rename}},
+		{"Renamed attribute",
+			doc("", el("root", el("customer", attr("id", "1"), el("name", dat("John"))))),
+			doc("", el("root", el("customer", attr("key", "1"), el("name", dat("John"))))),
+			1, []Operation{Rename}},
+		{"Renamed element with changed child",
+			doc("", el("root", el("customer", el("name", dat("John")), el("city", dat("Oslo"))))),
+			doc("", el("root", el("client", el("name", dat("John")), el("city", dat("Rome"))))),
+			0.5, []Operation{Rename, DeleteSubtree, InsertSubtree}},
+		{"Changed contents below threshold",
+			doc("", el("root", el("customer", el("name", dat("John")), el("city", dat("Oslo"))))),
+			doc("", el("root", el("client", el("name", dat("John")), el("city", dat("Rome"))))),
+			0.8, []Operation{DeleteSubtree, InsertSubtree}},
+		{"Disabled",
+			doc("", el("root", el("customer", el("name", dat("John"))))),
+			doc("", el("root", el("client", el("name", dat("John"))))),
+			0, []Operation{DeleteSubtree, InsertSubtree}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xtree.Prepare(tt.left)
+			xtree.Prepare(tt.right)
+			c := NewComparer()
+			c.DetectRenames = tt.threshold > 0
+			c.RenameThreshold = tt.threshold
+			got, err := c.Compare(tt.left, tt.right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Compare() =\n%v, want %v", got, tt.want)
+			}
+			for i, d := range got {
+				if d.Operation != tt.want[i] {
+					t.Errorf("Compare() =\n%v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareNamespaces(t *testing.T) {
+	tests := []struct {
+		name           string
+		left           *xtree.Node
+		right          *xtree.Node
+		ignorePrefixes bool
+		want           []Operation
+	}{
+		{"Changed prefix",
+			doc("", el("a:root", attr("xmlns:a", "urn:x"), el("a:item", dat("1")))),
+			doc("", el("b:root", attr("xmlns:b", "urn:x"), el("b:item", dat("2")))),
+			false, []Operation{Delete, Update, Insert}},
+		{"Ignored prefix",
+			doc("", el("a:root", attr("xmlns:a", "urn:x"), el("a:item", dat("1")))),
+			doc("", el("b:root", attr("xmlns:b", "urn:x"), el("b:item", dat("2")))),
+			true, []Operation{Update}},
+		{"Default namespace",
+			doc("", el("root", attr("xmlns", "urn:x"), el("item", dat("1")))),
+			doc("", el("x:root", attr("xmlns:x", "urn:x"), el("x:item", dat("1")))),
+			true, nil},
+		{"Different namespaces",
+			doc("", el("root", attr("xmlns:a", "urn:x"), attr("xmlns:b", "urn:y"), el("a:item", dat("1")))),
+			doc("", el("root", attr("xmlns:a", "urn:x"), attr("xmlns:b", "urn:y"), el("b:item", dat("1")))),
+			false, []Operation{DeleteSubtree, InsertSubtree}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xtree.ResolveNamespaces(tt.left)
+			xtree.ResolveNamespaces(tt.right)
+			xtree.Prepare(tt.left)
+			xtree.Prepare(tt.right)
+			c := NewComparer()
+			c.IgnorePrefixes = tt.ignorePrefixes
+			got, err := c.Compare(tt.left, tt.right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Compare() =\n%v, want %v", got, tt.want)
+			}
+			for i, d := range got {
+				if d.Operation != tt.want[i] {
+					t.Errorf("Compare() =\n%v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestComparePolicyNamespaces(t *testing.T) {
+	const uri = "http://maven.apache.org/POM/4.0.0"
+	pom := func(prefix, first, second string) *xtree.Node {
+		ns := "xmlns"
+		if prefix != "" {
+			ns += ":" + strings.TrimSuffix(prefix, ":")
+		}
+		n := doc("",
+			el(prefix+"project", attr(ns, uri),
+				el(prefix+"goals",
+					el(prefix+"goal", dat(first)),
+					el(prefix+"goal", dat(second))),
+				el(prefix+"dependencies",
+					el(prefix+"dependency",
+						el(prefix+"artifactId", dat(first)),
+						el(prefix+"version", dat("1"))))))
+		xtree.ResolveNamespaces(n)
+		xtree.Prepare(n)
+		return n
+	}
+	tests := []struct {
+		name    string
+		prefix  string
+		ordered string
+		keys    string
+		key     string
+	}{
+		{"Local names", "", "/project/goals", "/project/dependencies/dependency", "artifactId"},
+		{"Local names of prefixed nodes", "p:", "/project/goals", "/project/dependencies/dependency/Element", "artifactId"},
+		{"Prefixed names", "p:", "/p:project/p:goals", "/p:project/p:dependencies/p:dependency", "p:artifactId"},
+		{"Expanded names", "",
+			"/{" + uri + "}project/{" + uri + "}goals",
+			"/{" + uri + "}project/{" + uri + "}dependencies/{" + uri + "}dependency", "{" + uri + "}artifactId"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewComparer()
+			c.OrderPolicy = NewSignaturePolicy(tt.ordered)
+			sk := NewSignatureKeys()
+			sk.Set(tt.keys, tt.key)
+			c.Keys = sk
+			got, err := c.Compare(pom(tt.prefix, "a", "b"), pom(tt.prefix, "b", "a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Goals are reordered and the dependency with a different key
+			// is replaced instead of updated.
+			want := []Operation{Reorder, DeleteSubtree, InsertSubtree}
+			if len(got) != len(want) {
+				t.Fatalf("Compare() =\n%v, want %v", got, want)
+			}
+			for i, d := range got {
+				if d.Operation != want[i] {
+					t.Fatalf("Compare() =\n%v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
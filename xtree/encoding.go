@@ -10,16 +10,32 @@ import (
 
 // TextEncoder knows how to encode node tree as text format suitable for command line output.
 type TextEncoder struct {
+	// Label returns the text printed for the node, by default it's the
+	// String of the node.
+	Label func(n *Node) string
+	// Visible reports if the node and its subtree are printed, by default
+	// all nodes are. Consecutive hidden siblings are printed as a single
+	// line with their number, unless all of the siblings are hidden.
+	Visible func(n *Node) bool
+
 	p textPrinter
 }
 
 // NewTextEncoder creates new textual encoder that writes text into the writer.
 func NewTextEncoder(w io.Writer) *TextEncoder {
-	return &TextEncoder{textPrinter{Writer: bufio.NewWriter(w)}}
+	return &TextEncoder{p: textPrinter{Writer: bufio.NewWriter(w)}}
 }
 
 // Encode writes node tree rooted at n to the stream.
 func (enc *TextEncoder) Encode(n *Node) error {
+	enc.p.label = enc.Label
+	if enc.p.label == nil {
+		enc.p.label = (*Node).String
+	}
+	enc.p.visible = enc.Visible
+	if enc.p.visible == nil {
+		enc.p.visible = func(*Node) bool { return true }
+	}
 	if err := enc.p.printText(n); err != nil {
 		return err
 	}
@@ -28,9 +44,20 @@ func (enc *TextEncoder) Encode(n *Node) error {
 
 type textPrinter struct {
 	*bufio.Writer
+
+	label   func(n *Node) string
+	visible func(n *Node) bool
+}
+
+// textLine is the line of the printed node, or of the hidden siblings
+// ending with the node.
+type textLine struct {
+	n      *Node
+	hidden int
 }
 
-func (p *textPrinter) writeLine(current *Node) error {
+func (p *textPrinter) writeLine(line textLine) error {
+	current := line.n
 	indent := func(n *Node) string {
 		var out []string
 		for parent := n.Parent; parent != nil; parent = parent.Parent {
@@ -51,40 +78,73 @@ func (p *textPrinter) writeLine(current *Node) error {
 		} else {
 			out = "├──"
 		}
-		if n.FirstChild != nil {
+		if line.hidden == 0 && p.firstVisible(n.FirstChild) != nil {
 			out += "┐"
 		} else {
 			out += "─"
 		}
 		return out
 	}
+	label := ""
+	if line.hidden > 0 {
+		label = fmt.Sprintf("… %d more", line.hidden)
+	} else {
+		label = p.label(current)
+	}
 	_, err := p.WriteString(fmt.Sprintf("%s%s%s\n",
 		indent(current),
 		branching(current),
-		current.String()))
+		label))
 	return err
 }
 
+// firstVisible returns the first visible node among n and its next siblings.
+func (p *textPrinter) firstVisible(n *Node) *Node {
+	for n != nil && !p.visible(n) {
+		n = n.NextSibling
+	}
+	return n
+}
+
+// pushSiblings pushes the first visible node among n and its next siblings,
+// preceded by the line of hidden siblings if there are any.
+func (p *textPrinter) pushSiblings(s []textLine, n *Node) []textLine {
+	var line textLine
+	for ; n != nil && !p.visible(n); n = n.NextSibling {
+		line.n = n
+		line.hidden++
+	}
+	if n != nil {
+		s = append(s, textLine{n: n})
+	}
+	if line.hidden > 0 {
+		s = append(s, line)
+	}
+	return s
+}
+
 func (p *textPrinter) printText(n *Node) error {
 	if n == nil {
 		return nil
 	}
-	s := Stack{}
-	s.Push(n)
-	for !s.IsEmpty() {
-		current, _ := s.Pop()
+	s := []textLine{{n: n}}
+	for len(s) > 0 {
+		current := s[len(s)-1]
+		s = s[:len(s)-1]
 		if err := p.writeLine(current); err != nil {
 			return err
 		}
-		if current.NextSibling != nil {
-			if !s.Push(current.NextSibling) {
-				return fmt.Errorf("tree: maximum tree depth of %d reached", maxStackSize)
-			}
+		if current.hidden > 0 {
+			continue
 		}
-		if current.FirstChild != nil {
-			if !s.Push(current.FirstChild) {
-				return fmt.Errorf("tree: maximum tree depth of %d reached", maxStackSize)
-			}
+		if current.n.NextSibling != nil {
+			s = p.pushSiblings(s, current.n.NextSibling)
+		}
+		if p.firstVisible(current.n.FirstChild) != nil {
+			s = p.pushSiblings(s, current.n.FirstChild)
+		}
+		if len(s) > maxStackSize {
+			return fmt.Errorf("tree: maximum tree depth of %d reached", maxStackSize)
 		}
 	}
 	return nil
@@ -115,6 +175,11 @@ func (enc *XMLEncoder) Indent(indent string) {
 	enc.p.indent = indent
 }
 
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;")
+)
+
 type xmlPrinter struct {
 	*bufio.Writer
 
@@ -180,7 +245,7 @@ func (p *xmlPrinter) writeNode(n *Node) error {
 		if err != nil {
 			return err
 		}
-		_, err = p.Write(n.Value)
+		_, err = attrEscaper.WriteString(p, string(n.Value))
 		if err != nil {
 			return err
 		}
@@ -202,7 +267,7 @@ func (p *xmlPrinter) writeNode(n *Node) error {
 			}
 		}
 	case Data:
-		_, err := p.Write(n.Value)
+		_, err := textEscaper.WriteString(p, string(n.Value))
 		if err != nil {
 			return err
 		}
@@ -215,7 +280,7 @@ func (p *xmlPrinter) writeNode(n *Node) error {
 		if err != nil {
 			return err
 		}
-		_, err = p.WriteString("]]")
+		_, err = p.WriteString("]]>")
 		if err != nil {
 			return err
 		}
@@ -281,6 +346,16 @@ func (p *xmlPrinter) writeNode(n *Node) error {
 		if err != nil {
 			return err
 		}
+		if len(n.Name) > 0 {
+			_, err = p.Write(n.Name)
+			if err != nil {
+				return err
+			}
+			err = p.WriteByte(' ')
+			if err != nil {
+				return err
+			}
+		}
 		_, err = p.Write(n.Value)
 		if err != nil {
 			return err
@@ -302,7 +377,7 @@ func (p *xmlPrinter) writeClosing(n *Node) error {
 	switch n.Type {
 	case Document, Attribute, Data, CData, Comment, Doctype, ProcInstr:
 	case Element:
-		if n.LastChild() != nil && n.LastChild().Type != Data {
+		if last := n.LastChild(); last != nil && last.Type != Data && last.Type != Attribute {
 			if err := p.writeIndent(n); err != nil {
 				return err
 			}
@@ -357,10 +432,12 @@ func (p *xmlPrinter) printXML(n *Node) error {
 				return fmt.Errorf("tree: maximum tree depth of %d reached", maxStackSize)
 			}
 		}
-		if current.NextSibling == nil && current.FirstChild == nil {
+		if current.FirstChild == nil {
 			if err := p.writeClosing(current); err != nil {
 				return err
 			}
+		}
+		if current.NextSibling == nil && current.FirstChild == nil {
 			for closing := current.Parent; closing != nil; closing = closing.Parent {
 				if !s.IsEmpty() && closing == s.Peek().Parent {
 					break
@@ -19,6 +19,29 @@ func testTree() *Node {
 	return doc
 }
 
+func emptyElementsTree() *Node {
+	doc := NewDocument(nil)
+	root := NewElement([]byte("root"))
+	doc.AppendChild(root)
+	root.AppendChild(NewElement([]byte("a")))
+	root.AppendChild(NewData([]byte("text")))
+	root.AppendChild(NewElement([]byte("b")))
+	Prepare(doc)
+	return doc
+}
+
+func escapedTree() *Node {
+	doc := NewDocument(nil)
+	root := NewElement([]byte("root"))
+	doc.AppendChild(root)
+	root.AppendChild(NewAttribute([]byte("a"), []byte(`"x" & <y>`)))
+	root.AppendChild(NewData([]byte("1 < 2")))
+	root.AppendChild(NewCData([]byte("<raw>")))
+	root.AppendChild(NewProcInstr([]byte("target"), []byte("value")))
+	Prepare(doc)
+	return doc
+}
+
 func TestTextEncoding(t *testing.T) {
 	doc := testTree()
 	tests := []struct {
@@ -53,6 +76,81 @@ func TestTextEncoding(t *testing.T) {
 	}
 }
 
+func TestTextEncodingVisible(t *testing.T) {
+	doc := NewDocument(nil)
+	root := NewElement([]byte("root"))
+	doc.AppendChild(root)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		root.AppendChild(NewElement([]byte(name)))
+	}
+	root.FirstChild.AppendChild(NewData([]byte("hidden")))
+	Prepare(doc)
+	hidden := map[string]bool{"a": true, "c": true, "d": true}
+	tests := []struct {
+		name    string
+		visible func(n *Node) bool
+		want    string
+	}{
+		{
+			"hidden siblings",
+			func(n *Node) bool { return !hidden[string(n.Name)] },
+			`───┐/
+   └──┐<root>
+      ├───… 1 more
+      ├───<b>
+      ├───… 2 more
+      └───<e>
+`,
+		},
+		{
+			"hidden children",
+			func(n *Node) bool { return n.Type != Data },
+			`───┐/
+   └──┐<root>
+      ├───<a>
+      ├───<b>
+      ├───<c>
+      ├───<d>
+      └───<e>
+`,
+		},
+		{
+			"trailing hidden siblings",
+			func(n *Node) bool { return string(n.Name) != "d" && string(n.Name) != "e" },
+			`───┐/
+   └──┐<root>
+      ├──┐<a>
+      │  └───hidden
+      ├───<b>
+      ├───<c>
+      └───… 2 more
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &bytes.Buffer{}
+			enc := NewTextEncoder(w)
+			enc.Visible = tt.visible
+			enc.Label = func(n *Node) string {
+				switch n.Type {
+				case Document:
+					return "/"
+				case Element:
+					return "<" + string(n.Name) + ">"
+				}
+				return string(n.Value)
+			}
+			if err := enc.Encode(doc); err != nil {
+				t.Errorf("TextEncoder.Encode() = error %v", err)
+			}
+			if w.String() != tt.want {
+				t.Errorf("TextEncoder.Encode() = \n%v\nexpected:\n%v", w.String(), tt.want)
+			}
+		})
+	}
+}
+
 func TestXMLEncoding(t *testing.T) {
 	doc := testTree()
 	tests := []struct {
@@ -67,6 +165,18 @@ func TestXMLEncoding(t *testing.T) {
 			`<root><child1 subchild="value"></child1><child2></child2></root>`,
 			nil,
 		},
+		{
+			"empty elements",
+			emptyElementsTree(),
+			`<root><a></a>text<b></b></root>`,
+			nil,
+		},
+		{
+			"escaped",
+			escapedTree(),
+			`<root a="&quot;x&quot; &amp; &lt;y>">1 &lt; 2<![CDATA[<raw>]]><?target value?></root>`,
+			nil,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,112 @@
+package xtree
+
+import "bytes"
+
+// Namespaces bound to the reserved prefixes.
+const (
+	XMLNamespace   = "http://www.w3.org/XML/1998/namespace"
+	XMLNSNamespace = "http://www.w3.org/2000/xmlns/"
+)
+
+// Prefix returns namespace prefix of the element or attribute name, or nil
+// if the name is not prefixed.
+func (n *Node) Prefix() []byte {
+	if n.Type != Element && n.Type != Attribute {
+		return nil
+	}
+	if i := bytes.IndexByte(n.Name, ':'); i > 0 {
+		return n.Name[:i]
+	}
+	return nil
+}
+
+// LocalName returns the element or attribute name without its namespace
+// prefix, and the name of the other nodes.
+func (n *Node) LocalName() []byte {
+	if n.Type != Element && n.Type != Attribute {
+		return n.Name
+	}
+	if i := bytes.IndexByte(n.Name, ':'); i > 0 {
+		return n.Name[i+1:]
+	}
+	return n.Name
+}
+
+// ExpandedName returns the name in the form {namespace}local for the nodes
+// in a namespace, and the name of the other nodes. Nodes with the same
+// expanded name have the same name regardless of their prefixes.
+func (n *Node) ExpandedName() []byte {
+	if len(n.Namespace) == 0 {
+		return n.Name
+	}
+	local := n.LocalName()
+	name := make([]byte, 0, len(n.Namespace)+len(local)+2)
+	name = append(name, '{')
+	name = append(name, n.Namespace...)
+	name = append(name, '}')
+	return append(name, local...)
+}
+
+// IsNamespaceDeclaration reports if the node is xmlns attribute declaring
+// the namespace.
+func (n *Node) IsNamespaceDeclaration() bool {
+	return n.Type == Attribute &&
+		(string(n.Name) == "xmlns" || bytes.Equal(n.Prefix(), []byte("xmlns")))
+}
+
+// ResolveNamespaces sets namespaces of the elements and attributes in the
+// xtree rooted at n from the xmlns attributes in their scope. Unprefixed
+// attributes have no namespace and names with undeclared prefixes stay
+// unresolved.
+func ResolveNamespaces(n *Node) {
+	type frame struct {
+		n     *Node
+		scope map[string][]byte
+	}
+	root := map[string][]byte{
+		"xml":   []byte(XMLNamespace),
+		"xmlns": []byte(XMLNSNamespace),
+	}
+	s := []frame{{n, root}}
+	for len(s) > 0 {
+		f := s[len(s)-1]
+		s = s[:len(s)-1]
+		scope := f.scope
+		if f.n.Type == Element {
+			copied := false
+			for ch := f.n.FirstChild; ch != nil && ch.Type == Attribute; ch = ch.NextSibling {
+				if !ch.IsNamespaceDeclaration() {
+					continue
+				}
+				if !copied {
+					scope = make(map[string][]byte, len(f.scope)+1)
+					for prefix, uri := range f.scope {
+						scope[prefix] = uri
+					}
+					copied = true
+				}
+				prefix := ""
+				if len(ch.Prefix()) > 0 {
+					prefix = string(ch.LocalName())
+				}
+				if len(ch.Value) == 0 {
+					delete(scope, prefix)
+				} else {
+					scope[prefix] = ch.Value
+				}
+			}
+			f.n.Namespace = scope[string(f.n.Prefix())]
+		}
+		if f.n.Type == Attribute {
+			f.n.Namespace = nil
+			if f.n.IsNamespaceDeclaration() {
+				f.n.Namespace = []byte(XMLNSNamespace)
+			} else if prefix := f.n.Prefix(); prefix != nil {
+				f.n.Namespace = scope[string(prefix)]
+			}
+		}
+		for ch := f.n.FirstChild; ch != nil; ch = ch.NextSibling {
+			s = append(s, frame{ch, scope})
+		}
+	}
+}
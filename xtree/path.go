@@ -0,0 +1,120 @@
+package xtree
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path returns XPath-like path of the node from the root of the xtree, for
+// example "/project[1]/dependencies[1]/dependency[2]/@scope". Root of the
+// xtree has path "/". Every step has positional predicate counting siblings
+// of the same kind, except for attributes which are unique by name.
+//
+// Text steps "text()" count both data and CDATA nodes, the rest of non-element
+// nodes have steps named by their type like "comment()", "doctype()" and
+// "processing-instruction(target)". Directories, documents and non-XML files
+// have steps "directory(name)", "document(name)" and "file(name)".
+func (n *Node) Path() string {
+	if n.Parent == nil {
+		return "/"
+	}
+	parent := n.Parent.Path()
+	if parent == "/" {
+		parent = ""
+	}
+	steps := n.Parent.ChildSteps()
+	i := 0
+	for ch := n.Parent.FirstChild; ch != n; ch = ch.NextSibling {
+		i++
+	}
+	return parent + "/" + steps[i]
+}
+
+// ChildSteps returns path steps of the node's children in order.
+func (n *Node) ChildSteps() []string {
+	var steps []string
+	counts := make(map[string]int)
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		step := ch.stepName()
+		if ch.Type == Attribute {
+			steps = append(steps, step)
+			continue
+		}
+		counts[step]++
+		steps = append(steps, step+"["+strconv.Itoa(counts[step])+"]")
+	}
+	return steps
+}
+
+// stepName returns path step of the node without the positional predicate.
+func (n *Node) stepName() string {
+	switch n.Type {
+	case Element:
+		return string(n.Name)
+	case Attribute:
+		return "@" + string(n.Name)
+	case Data, CData:
+		return "text()"
+	case Comment:
+		return "comment()"
+	case Declaration:
+		return "declaration()"
+	case Doctype:
+		return "doctype()"
+	case ProcInstr:
+		return "processing-instruction(" + string(n.Name) + ")"
+	case Directory:
+		return "directory(" + string(n.Name) + ")"
+	case Document:
+		return "document(" + string(n.Name) + ")"
+	default:
+		return "file(" + string(n.Name) + ")"
+	}
+}
+
+// Find returns the node at the path relative to the root of the xtree.
+func (n *Node) Find(path string) (*Node, error) {
+	for n.Parent != nil {
+		n = n.Parent
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("xtree: path %q is not absolute", path)
+	}
+	if path == "/" {
+		return n, nil
+	}
+	for _, step := range strings.Split(path[1:], "/") {
+		child, err := n.findChild(step)
+		if err != nil {
+			return nil, fmt.Errorf("xtree: node %q not found: %v", path, err)
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// findChild returns the child of the node matching the path step.
+func (n *Node) findChild(step string) (*Node, error) {
+	name, position := step, 1
+	if strings.HasSuffix(step, "]") {
+		i := strings.LastIndex(step, "[")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid step %q", step)
+		}
+		p, err := strconv.Atoi(step[i+1 : len(step)-1])
+		if err != nil || p < 1 {
+			return nil, fmt.Errorf("invalid position in step %q", step)
+		}
+		name, position = step[:i], p
+	}
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		if ch.stepName() != name {
+			continue
+		}
+		if position--; position == 0 {
+			return ch, nil
+		}
+	}
+	return nil, fmt.Errorf("no child matching step %q", step)
+}
@@ -0,0 +1,79 @@
+package xtree
+
+import "testing"
+
+func TestPath(t *testing.T) {
+	doc := NewDocument([]byte("pom.xml"))
+	root := NewNode(Element)
+	root.Name = []byte("root")
+	doc.AppendChild(root)
+	children := []*Node{
+		{Type: Attribute, Name: []byte("id")},
+		{Type: Element, Name: []byte("a")},
+		{Type: Data, Value: []byte("text")},
+		{Type: Element, Name: []byte("b")},
+		{Type: CData, Value: []byte("cdata")},
+		{Type: Element, Name: []byte("a")},
+		{Type: Comment, Value: []byte("comment")},
+		{Type: ProcInstr, Name: []byte("pi")},
+	}
+	for _, ch := range children {
+		root.AppendChild(ch)
+	}
+	dir := NewDirectory([]byte("dir"))
+	dir.AppendChild(doc)
+
+	tests := []struct {
+		node *Node
+		path string
+	}{
+		{dir, "/"},
+		{doc, "/document(pom.xml)[1]"},
+		{root, "/document(pom.xml)[1]/root[1]"},
+		{children[0], "/document(pom.xml)[1]/root[1]/@id"},
+		{children[1], "/document(pom.xml)[1]/root[1]/a[1]"},
+		{children[2], "/document(pom.xml)[1]/root[1]/text()[1]"},
+		{children[3], "/document(pom.xml)[1]/root[1]/b[1]"},
+		{children[4], "/document(pom.xml)[1]/root[1]/text()[2]"},
+		{children[5], "/document(pom.xml)[1]/root[1]/a[2]"},
+		{children[6], "/document(pom.xml)[1]/root[1]/comment()[1]"},
+		{children[7], "/document(pom.xml)[1]/root[1]/processing-instruction(pi)[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := tt.node.Path(); got != tt.path {
+				t.Errorf("Path() = %q, want %q", got, tt.path)
+			}
+			found, err := root.Find(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.node {
+				t.Errorf("Find(%q) = %v, want %v", tt.path, found, tt.node)
+			}
+		})
+	}
+}
+
+func TestFindErrors(t *testing.T) {
+	root := NewNode(Element)
+	root.Name = []byte("root")
+	doc := NewDocument(nil)
+	doc.AppendChild(root)
+	for _, path := range []string{
+		"",
+		"root[1]",
+		"/root[2]",
+		"/root[0]",
+		"/root[x]",
+		"/root]",
+		"/root[1]/a[1]",
+		"/root[1]/@id",
+	} {
+		t.Run(path, func(t *testing.T) {
+			if _, err := doc.Find(path); err == nil {
+				t.Errorf("Find(%q) succeeded, want error", path)
+			}
+		})
+	}
+}
@@ -56,6 +56,36 @@ type Node struct {
 	Value             []byte
 	Hash              []byte
 	Signature         []byte
+	// Namespace is the URI of the element's or attribute's namespace,
+	// empty if it's not in a namespace. See ResolveNamespaces.
+	Namespace []byte
+	// Start and End are the positions of the first byte of the node and
+	// the byte after its last one in the parsed document.
+	Start, End Position
+}
+
+// Position is the location in the parsed document. Offsets and columns of
+// the documents converted from other encodings to UTF-8 count bytes of the
+// source, not of the converted text.
+type Position struct {
+	// Offset in bytes, starting at 0.
+	Offset int
+	// Line and Column in bytes, starting at 1. Unknown position has
+	// line 0.
+	Line, Column int
+}
+
+// IsValid reports if the position is known.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// Implements stringer, position is printed as line:column.
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 // NewNode creates new node of type t.
@@ -188,7 +218,7 @@ func (n *Node) CalculateHash(h hash.Hash) error {
 	if err != nil {
 		return err
 	}
-	_, err = h.Write(n.Name)
+	_, err = h.Write(n.ExpandedName())
 	if err != nil {
 		return err
 	}
@@ -206,22 +236,40 @@ func (n *Node) CalculateHash(h hash.Hash) error {
 	return nil
 }
 
-// CalculateSignature sets signature value of the node.
+// CalculateSignature sets signature value of the node. Names of the
+// namespaced nodes are expanded to {uri}local.
 func (n *Node) CalculateSignature() {
+	n.Signature = n.signature((*Node).ExpandedName)
+}
+
+// QualifiedSignature returns signature of the node made of the names as
+// they are in the document, with their namespace prefixes.
+func (n *Node) QualifiedSignature() []byte {
+	return n.signature(func(m *Node) []byte { return m.Name })
+}
+
+// LocalSignature returns signature of the node made of the local names,
+// without the namespaces.
+func (n *Node) LocalSignature() []byte {
+	return n.signature((*Node).LocalName)
+}
+
+// signature returns signature of the node made of the names of the node and
+// its ancestors.
+func (n *Node) signature(name func(*Node) []byte) []byte {
+	if n.Parent == nil {
+		return []byte{0x2f}
+	}
 	var sig [][]byte
 	for parent := n.Parent; parent != nil; parent = parent.Parent {
-		sig = append([][]byte{parent.Name}, sig...)
+		sig = append([][]byte{name(parent)}, sig...)
 	}
-	if n.Parent != nil {
-		if len(n.Name) > 0 {
-			sig = append(sig, n.Name, n.Type.Signature())
-		} else {
-			sig = append(sig, n.Type.Signature())
-		}
-		n.Signature = bytes.Join(sig, []byte{0x2f})
+	if len(n.Name) > 0 {
+		sig = append(sig, name(n), n.Type.Signature())
 	} else {
-		n.Signature = []byte{0x2f}
+		sig = append(sig, n.Type.Signature())
 	}
+	return bytes.Join(sig, []byte{0x2f})
 }
 
 // LastChild returns last child of the node.
@@ -242,13 +290,18 @@ func (n *Node) PrevSibling() *Node {
 
 // Remove removes node from the xtree.
 func (n *Node) Remove() {
-	if n.Parent != nil && n.Parent.FirstChild == n {
-		n.Parent.FirstChild = n.NextSibling
+	if n.Parent != nil {
+		if n.Parent.FirstChild == n {
+			n.Parent.FirstChild = n.NextSibling
+		} else if n.NextSibling == nil {
+			// Last child is removed so the first one must point to the new last.
+			n.Parent.FirstChild.PrevSiblingCyclic = n.PrevSiblingCyclic
+		}
 	}
 	if n.NextSibling != nil {
 		n.NextSibling.PrevSiblingCyclic = n.PrevSiblingCyclic
 	}
-	if n.PrevSiblingCyclic.NextSibling == n {
+	if n.PrevSiblingCyclic != nil && n.PrevSiblingCyclic.NextSibling == n {
 		n.PrevSiblingCyclic.NextSibling = n.NextSibling
 	}
 	n.NextSibling = nil
@@ -270,6 +323,50 @@ func (n *Node) AppendChild(child *Node) *Node {
 	return n
 }
 
+// InsertBefore inserts child node before the ref node, which must be child
+// of the node. If ref is nil child is appended to the node.
+func (n *Node) InsertBefore(child, ref *Node) *Node {
+	if ref == nil {
+		return n.AppendChild(child)
+	}
+	child.Parent = n
+	child.NextSibling = ref
+	child.PrevSiblingCyclic = ref.PrevSiblingCyclic
+	if n.FirstChild == ref {
+		n.FirstChild = child
+	} else {
+		ref.PrevSiblingCyclic.NextSibling = child
+	}
+	ref.PrevSiblingCyclic = child
+	return n
+}
+
+// Clone returns deep copy of the subtree rooted at the node. The copy is
+// detached from the xtree.
+func (n *Node) Clone() *Node {
+	c := &Node{
+		Type:      n.Type,
+		Name:      cloneBytes(n.Name),
+		Value:     cloneBytes(n.Value),
+		Hash:      cloneBytes(n.Hash),
+		Signature: cloneBytes(n.Signature),
+		Namespace: cloneBytes(n.Namespace),
+		Start:     n.Start,
+		End:       n.End,
+	}
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		c.AppendChild(ch.Clone())
+	}
+	return c
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
 // Prepare traverses the xtree rooted at n and sets signature and hash for
 // all nodes.
 func Prepare(n *Node) error {
@@ -1 +1,145 @@
 package xtree
+
+import (
+	"testing"
+)
+
+func TestRemove(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove func(n *Node) *Node
+		want   string
+	}{
+		{"First", func(n *Node) *Node { return n.FirstChild }, "bc"},
+		{"Middle", func(n *Node) *Node { return n.FirstChild.NextSibling }, "ac"},
+		{"Last", func(n *Node) *Node { return n.LastChild() }, "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewElement([]byte("root"))
+			n.AppendChild(NewElement([]byte("a")))
+			n.AppendChild(NewElement([]byte("b")))
+			n.AppendChild(NewElement([]byte("c")))
+			tt.remove(n).Remove()
+			if got, back := childNames(n); got != tt.want || back != tt.want {
+				t.Errorf("children = %s, backwards %s, want %s", got, back, tt.want)
+			}
+		})
+	}
+}
+
+func TestInsertBefore(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  func(n *Node) *Node
+		want string
+	}{
+		{"Before first", func(n *Node) *Node { return n.FirstChild }, "xab"},
+		{"Before last", func(n *Node) *Node { return n.LastChild() }, "axb"},
+		{"Append", func(n *Node) *Node { return nil }, "abx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewElement([]byte("root"))
+			n.AppendChild(NewElement([]byte("a")))
+			n.AppendChild(NewElement([]byte("b")))
+			n.InsertBefore(NewElement([]byte("x")), tt.ref(n))
+			for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+				if ch.Parent != n {
+					t.Errorf("parent of %s = %v, want root", ch.Name, ch.Parent)
+				}
+			}
+			if got, back := childNames(n); got != tt.want || back != tt.want {
+				t.Errorf("children = %s, backwards %s, want %s", got, back, tt.want)
+			}
+		})
+	}
+}
+
+func TestClone(t *testing.T) {
+	n := testTree()
+	if err := Prepare(n); err != nil {
+		t.Fatal(err)
+	}
+	c := n.Clone()
+	if c.Parent != nil || c.NextSibling != nil {
+		t.Error("clone is not detached")
+	}
+	want, err := TextString(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.FirstChild.Name[0] = 'X'
+	if got, _ := TextString(n); got != want {
+		t.Errorf("changing clone changed the original:\n%s", got)
+	}
+	c.FirstChild.Name[0] = n.FirstChild.Name[0]
+	if got, _ := TextString(c); got != want {
+		t.Errorf("Clone() =\n%s, want\n%s", got, want)
+	}
+}
+
+// childNames joins names of the node's children going forward and backward.
+func childNames(n *Node) (string, string) {
+	var forward, backward string
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		forward += string(ch.Name)
+	}
+	for ch := n.LastChild(); ch != nil; ch = ch.PrevSibling() {
+		backward = string(ch.Name) + backward
+	}
+	return forward, backward
+}
+
+func TestResolveNamespaces(t *testing.T) {
+	root := NewElement([]byte("root"))
+	root.AppendChild(NewAttribute([]byte("xmlns"), []byte("urn:default")))
+	root.AppendChild(NewAttribute([]byte("xmlns:a"), []byte("urn:a")))
+	root.AppendChild(NewAttribute([]byte("id"), []byte("1")))
+	root.AppendChild(NewAttribute([]byte("xml:lang"), []byte("en")))
+	item := NewElement([]byte("a:item"))
+	root.AppendChild(item)
+	item.AppendChild(NewAttribute([]byte("a:id"), []byte("2")))
+	inner := NewElement([]byte("inner"))
+	root.AppendChild(inner)
+	inner.AppendChild(NewAttribute([]byte("xmlns"), []byte("")))
+	inner.AppendChild(NewAttribute([]byte("xmlns:a"), []byte("urn:other")))
+	innerItem := NewElement([]byte("a:item"))
+	inner.AppendChild(innerItem)
+	unknown := NewElement([]byte("b:item"))
+	inner.AppendChild(unknown)
+	doc := NewDocument(nil)
+	doc.AppendChild(root)
+	ResolveNamespaces(doc)
+	tests := []struct {
+		n    *Node
+		want string
+	}{
+		{root, "{urn:default}root"},
+		{root.FirstChild, "{" + XMLNSNamespace + "}xmlns"},
+		{root.FirstChild.NextSibling, "{" + XMLNSNamespace + "}a"},
+		{root.FirstChild.NextSibling.NextSibling, "id"},
+		{root.FirstChild.NextSibling.NextSibling.NextSibling, "{" + XMLNamespace + "}lang"},
+		{item, "{urn:a}item"},
+		{item.FirstChild, "{urn:a}id"},
+		{inner, "inner"},
+		{innerItem, "{urn:other}item"},
+		{unknown, "b:item"},
+	}
+	for _, tt := range tests {
+		if got := string(tt.n.ExpandedName()); got != tt.want {
+			t.Errorf("ExpandedName() of %s = %s, want %s", tt.n.Name, got, tt.want)
+		}
+	}
+	id := item.FirstChild
+	id.CalculateSignature()
+	if got, want := string(id.Signature), "/{urn:default}root/{urn:a}item/{urn:a}id/Attribute"; got != want {
+		t.Errorf("Signature = %s, want %s", got, want)
+	}
+	if got, want := string(id.QualifiedSignature()), "/root/a:item/a:id/Attribute"; got != want {
+		t.Errorf("QualifiedSignature() = %s, want %s", got, want)
+	}
+	if got, want := string(id.LocalSignature()), "/root/item/id/Attribute"; got != want {
+		t.Errorf("LocalSignature() = %s, want %s", got, want)
+	}
+}